@@ -0,0 +1,54 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "grid_scheduler" "sched" {
+  # a rentable node, the nodes already rented by the twin are picked first
+  requests {
+    name                = "dedicated"
+    cru                 = 4
+    sru                 = 10240
+    mru                 = 8192
+    dedicated           = true
+    prefer_rented_by_me = true
+  }
+}
+
+resource "grid_rent_contract" "node" {
+  node = grid_scheduler.sched.nodes["dedicated"]
+}
+
+resource "grid_network" "net1" {
+  nodes       = [grid_rent_contract.node.node]
+  ip_range    = "10.1.0.0/16"
+  name        = "dedicated"
+  description = "network on the rented node"
+}
+
+resource "grid_deployment" "d1" {
+  node         = grid_rent_contract.node.node
+  network_name = grid_network.net1.name
+  vms {
+    name        = "vm1"
+    flist       = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu         = 4
+    memory      = 8192
+    rootfs_size = 10240
+    entrypoint  = "/sbin/zinit init"
+    env_vars = {
+      SSH_KEY = file("~/.ssh/id_rsa.pub")
+    }
+    planetary = true
+  }
+}
+
+output "rent_contract_id" {
+  value = grid_rent_contract.node.contract_id
+}
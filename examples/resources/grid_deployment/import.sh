@@ -0,0 +1,2 @@
+# a deployment is imported using the id of its node contract
+terraform import grid_deployment.d1 <contract_id>
@@ -0,0 +1,2 @@
+# a fqdn gateway is imported using its workload name and the id of its node contract
+terraform import grid_fqdn_proxy.p1 "name=example;contracts=18"
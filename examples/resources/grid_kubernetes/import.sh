@@ -0,0 +1,2 @@
+# a cluster is imported using its solution name and the ids of the node contracts of its master and workers
+terraform import grid_kubernetes.k8s1 "name=k8s1;contracts=15,16"
@@ -0,0 +1,2 @@
+# a name gateway is imported using its name and the id of its node contract
+terraform import grid_name_proxy.p1 "name=hamada;contracts=17"
@@ -0,0 +1,2 @@
+# a network is imported using its name and the ids of its node contracts, networks with wireguard access (add_wg_access) can't be imported
+terraform import grid_network.net1 "name=net1;contracts=12,13,14"
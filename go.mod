@@ -3,6 +3,7 @@ module github.com/threefoldtech/terraform-provider-grid
 go 1.18
 
 require (
+	github.com/centrifuge/go-substrate-rpc-client/v4 v4.0.5
 	github.com/google/uuid v1.3.0
 	github.com/gruntwork-io/terratest v0.41.23
 	github.com/hashicorp/go-multierror v1.1.1
@@ -10,6 +11,7 @@ require (
 	github.com/hashicorp/terraform-plugin-sdk/v2 v2.26.1
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.8.2
+	github.com/threefoldtech/tfchain/clients/tfchain-client-go v0.0.0-20230425112357-f35e342c2193
 	github.com/threefoldtech/tfgrid-sdk-go/grid-client v0.3.0
 	github.com/threefoldtech/tfgrid-sdk-go/grid-proxy v0.3.0
 	github.com/threefoldtech/tfgrid-sdk-go/rmb-sdk-go v0.3.0
@@ -37,7 +39,6 @@ require (
 	github.com/bgentry/speakeasy v0.1.0 // indirect
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/cenkalti/backoff/v3 v3.2.2 // indirect
-	github.com/centrifuge/go-substrate-rpc-client/v4 v4.0.5 // indirect
 	github.com/cosmos/go-bip39 v1.0.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/deckarep/golang-set v1.8.0 // indirect
@@ -107,7 +108,6 @@ require (
 	github.com/russross/blackfriday v1.6.0 // indirect
 	github.com/shopspring/decimal v1.3.1 // indirect
 	github.com/spf13/cast v1.5.0 // indirect
-	github.com/threefoldtech/tfchain/clients/tfchain-client-go v0.0.0-20230425112357-f35e342c2193 // indirect
 	github.com/tmccombs/hcl2json v0.3.3 // indirect
 	github.com/tyler-smith/go-bip39 v1.1.0 // indirect
 	github.com/ulikunitz/xz v0.5.10 // indirect
@@ -0,0 +1,107 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+)
+
+func dataSourceAccount() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Data source for reading the provider's account: its twin id, address and TFT balance.",
+
+		ReadContext: dataSourceAccountRead,
+
+		Schema: map[string]*schema.Schema{
+			"twin_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Twin id of the account.",
+			},
+			"address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "SS58 address of the account.",
+			},
+			"key_type": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Key type of the account, one of: sr25519 ed25519.",
+			},
+			"free_balance": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Free balance of the account, in TFT units (1 TFT = 10^7 units).",
+			},
+			"reserved_balance": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Reserved balance of the account, in TFT units (1 TFT = 10^7 units).",
+			},
+		},
+	}
+}
+
+// balanceToInt converts a chain balance to an int, failing instead of overflowing.
+func balanceToInt(balance *big.Int) (int, error) {
+	if balance == nil {
+		return 0, nil
+	}
+	if !balance.IsInt64() {
+		return 0, fmt.Errorf("balance %s overflows an int", balance)
+	}
+	return int(balance.Int64()), nil
+}
+
+func dataSourceAccountRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	balance, err := tfPluginClient.SubstrateConn.GetBalance(tfPluginClient.Identity)
+	if err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't get account balance"))
+	}
+
+	free, err := balanceToInt(balance.Free.Int)
+	if err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't convert free balance"))
+	}
+	reserved, err := balanceToInt(balance.Reserved.Int)
+	if err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't convert reserved balance"))
+	}
+
+	var errs error
+	if err := d.Set("twin_id", int(tfPluginClient.TwinID)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("address", tfPluginClient.Identity.Address()); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("key_type", tfPluginClient.Identity.Type()); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("free_balance", free); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("reserved_balance", reserved); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return diag.FromErr(errors.Wrap(errs, "couldn't set account"))
+	}
+
+	d.SetId(strconv.FormatUint(uint64(tfPluginClient.TwinID), 10))
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	proxy "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/client"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func dataSourceContracts() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Data source for listing the node, name and rent contracts of the provider's twin, with their state and linked solutions.",
+
+		ReadContext: dataSourceContractsRead,
+
+		Schema: map[string]*schema.Schema{
+			"type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Contract type, one of: node name rent.",
+			},
+			"states": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Description: "Contract states to list, e.g. Created GracePeriod Deleted. All states are listed if empty.",
+			},
+			"node_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Node id of the node and rent contracts.",
+			},
+			"contract_ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Description: "Ids of the matching contracts.",
+			},
+			"contracts": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "List of the matching contracts.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"contract_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Contract id.",
+						},
+						"type": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Contract type.",
+						},
+						"state": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Contract state.",
+						},
+						"created_at": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Contract creation unix timestamp.",
+						},
+						"node_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Node id of the node and rent contracts.",
+						},
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Registered name of the name contracts.",
+						},
+						"deployment_hash": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Deployment hash of the node contracts.",
+						},
+						"public_ips_count": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Count of public ips reserved by the node contracts.",
+						},
+						"solution_name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Solution name from the node contracts' deployment data.",
+						},
+						"solution_type": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Solution type from the node contracts' deployment data.",
+						},
+						"amount_billed": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Total amount billed for the contract, in TFT units (1 TFT = 10^7 units).",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// listContracts lists all the contracts matching the filter, going through all the grid proxy pages
+func listContracts(gridProxyClient proxy.Client, filter proxyTypes.ContractFilter) ([]proxyTypes.Contract, error) {
+	var contracts []proxyTypes.Contract
+	for page := uint64(1); ; page++ {
+		res, _, err := gridProxyClient.Contracts(filter, proxyTypes.Limit{
+			Size: proxyPageSize,
+			Page: page,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't list contracts from the grid proxy")
+		}
+		contracts = append(contracts, res...)
+		if len(res) < proxyPageSize {
+			return contracts, nil
+		}
+	}
+}
+
+// decodeContractDetails decodes the details of a contract into the struct of its type
+func decodeContractDetails(contract proxyTypes.Contract, details interface{}) error {
+	content, err := json.Marshal(contract.Details)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, details)
+}
+
+func flattenContract(contract proxyTypes.Contract) (map[string]interface{}, error) {
+	var amountBilled uint64
+	for _, billing := range contract.Billing {
+		amountBilled += billing.AmountBilled
+	}
+
+	res := map[string]interface{}{
+		"contract_id":   int(contract.ContractID),
+		"type":          contract.Type,
+		"state":         contract.State,
+		"created_at":    int(contract.CreatedAt),
+		"amount_billed": int(amountBilled),
+	}
+
+	switch contract.Type {
+	case "node":
+		var details proxyTypes.NodeContractDetails
+		if err := decodeContractDetails(contract, &details); err != nil {
+			return nil, errors.Wrapf(err, "couldn't decode node contract %d details", contract.ContractID)
+		}
+		res["node_id"] = int(details.NodeID)
+		res["deployment_hash"] = details.DeploymentHash
+		res["public_ips_count"] = int(details.NumberOfPublicIps)
+
+		// contracts created by other tools might not have deployment data
+		if deploymentData, err := workloads.ParseDeploymentData(details.DeploymentData); err == nil {
+			res["solution_name"] = deploymentData.Name
+			res["solution_type"] = deploymentData.ProjectName
+		}
+	case "name":
+		var details proxyTypes.NameContractDetails
+		if err := decodeContractDetails(contract, &details); err != nil {
+			return nil, errors.Wrapf(err, "couldn't decode name contract %d details", contract.ContractID)
+		}
+		res["name"] = details.Name
+	case "rent":
+		var details proxyTypes.RentContractDetails
+		if err := decodeContractDetails(contract, &details); err != nil {
+			return nil, errors.Wrapf(err, "couldn't decode rent contract %d details", contract.ContractID)
+		}
+		res["node_id"] = int(details.NodeID)
+	}
+	return res, nil
+}
+
+func dataSourceContractsRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	twinID := uint64(tfPluginClient.TwinID)
+	filter := proxyTypes.ContractFilter{
+		TwinID: &twinID,
+	}
+	if v, ok := d.GetOk("type"); ok {
+		contractType := v.(string)
+		filter.Type = &contractType
+	}
+	if v, ok := d.GetOk("node_id"); ok {
+		nodeID := uint64(v.(int))
+		filter.NodeID = &nodeID
+	}
+
+	contracts, err := listContracts(tfPluginClient.GridProxyClient, filter)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	states := make(map[string]bool)
+	for _, state := range d.Get("states").([]interface{}) {
+		states[state.(string)] = true
+	}
+
+	contractIDs := make([]int, 0, len(contracts))
+	flattened := make([]interface{}, 0, len(contracts))
+	for _, contract := range contracts {
+		if len(states) != 0 && !states[contract.State] {
+			continue
+		}
+
+		c, err := flattenContract(contract)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		contractIDs = append(contractIDs, int(contract.ContractID))
+		flattened = append(flattened, c)
+	}
+
+	if err := d.Set("contract_ids", contractIDs); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set contract ids"))
+	}
+	if err := d.Set("contracts", flattened); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set contracts"))
+	}
+
+	d.SetId(strconv.FormatInt(time.Now().Unix(), 10))
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	proxy "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/client"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func dataSourceFarms() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Data source for listing the grid farms matching some filters, with their public ips inventory.",
+
+		ReadContext: dataSourceFarmsRead,
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Farm name.",
+			},
+			"name_contains": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Part of the farm name.",
+			},
+			"farm_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Farm id.",
+			},
+			"twin_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Twin id of the farmer.",
+			},
+			"certification_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Certification type of the farms, e.g. Gold or NotCertified.",
+			},
+			"free_ips": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Minimum count of free public ips.",
+			},
+			"dedicated": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only dedicated (or shared) farms.",
+			},
+			"farm_ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Description: "Ids of the matching farms.",
+			},
+			"farms": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "List of the matching farms.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"farm_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Farm id.",
+						},
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Farm name.",
+						},
+						"twin_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Twin id of the farmer.",
+						},
+						"certification_type": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Certification type of the farm.",
+						},
+						"pricing_policy_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Id of the farm's pricing policy.",
+						},
+						"stellar_address": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Stellar address the farming rewards are paid to.",
+						},
+						"dedicated": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "True if all the farm nodes are dedicated.",
+						},
+						"free_ips": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Count of the farm's free public ips.",
+						},
+						"public_ips": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "Public ips of the farm.",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"ip": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Public ip with its subnet mask.",
+									},
+									"gateway": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Gateway of the public ip.",
+									},
+									"contract_id": {
+										Type:        schema.TypeInt,
+										Computed:    true,
+										Description: "Id of the contract using the public ip, 0 if it's free.",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newFarmFilterFromSchema reads the grid_farms data source filters from schema.ResourceData.
+func newFarmFilterFromSchema(d *schema.ResourceData) proxyTypes.FarmFilter {
+	var f proxyTypes.FarmFilter
+
+	if v, ok := d.GetOk("name"); ok {
+		name := v.(string)
+		f.Name = &name
+	}
+	if v, ok := d.GetOk("name_contains"); ok {
+		name := v.(string)
+		f.NameContains = &name
+	}
+	if v, ok := d.GetOk("farm_id"); ok {
+		id := uint64(v.(int))
+		f.FarmID = &id
+	}
+	if v, ok := d.GetOk("twin_id"); ok {
+		id := uint64(v.(int))
+		f.TwinID = &id
+	}
+	if v, ok := d.GetOk("certification_type"); ok {
+		certification := v.(string)
+		f.CertificationType = &certification
+	}
+	if v, ok := d.GetOk("free_ips"); ok {
+		count := uint64(v.(int))
+		f.FreeIPs = &count
+	}
+	// GetOk can't tell an unset bool from a false one
+	if v, ok := d.GetOkExists("dedicated"); ok { //nolint:staticcheck
+		dedicated := v.(bool)
+		f.Dedicated = &dedicated
+	}
+	return f
+}
+
+// listFarms lists all the farms matching the filter, going through all the grid proxy pages
+func listFarms(gridProxyClient proxy.Client, filter proxyTypes.FarmFilter) ([]proxyTypes.Farm, error) {
+	var farms []proxyTypes.Farm
+	for page := uint64(1); ; page++ {
+		res, _, err := gridProxyClient.Farms(filter, proxyTypes.Limit{
+			Size: proxyPageSize,
+			Page: page,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't list farms from the grid proxy")
+		}
+		farms = append(farms, res...)
+		if len(res) < proxyPageSize {
+			return farms, nil
+		}
+	}
+}
+
+func flattenFarm(farm proxyTypes.Farm) map[string]interface{} {
+	freeIPs := 0
+	publicIPs := make([]interface{}, 0, len(farm.PublicIps))
+	for _, ip := range farm.PublicIps {
+		if ip.ContractID == 0 {
+			freeIPs++
+		}
+		publicIPs = append(publicIPs, map[string]interface{}{
+			"ip":          ip.IP,
+			"gateway":     ip.Gateway,
+			"contract_id": ip.ContractID,
+		})
+	}
+
+	return map[string]interface{}{
+		"farm_id":            farm.FarmID,
+		"name":               farm.Name,
+		"twin_id":            farm.TwinID,
+		"certification_type": farm.CertificationType,
+		"pricing_policy_id":  farm.PricingPolicyID,
+		"stellar_address":    farm.StellarAddress,
+		"dedicated":          farm.Dedicated,
+		"free_ips":           freeIPs,
+		"public_ips":         publicIPs,
+	}
+}
+
+func dataSourceFarmsRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	farms, err := listFarms(tfPluginClient.GridProxyClient, newFarmFilterFromSchema(d))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	farmIDs := make([]int, 0, len(farms))
+	flattened := make([]interface{}, 0, len(farms))
+	for _, farm := range farms {
+		farmIDs = append(farmIDs, farm.FarmID)
+		flattened = append(flattened, flattenFarm(farm))
+	}
+
+	if err := d.Set("farm_ids", farmIDs); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set farm ids"))
+	}
+	if err := d.Set("farms", flattened); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set farms"))
+	}
+
+	d.SetId(strconv.FormatInt(time.Now().Unix(), 10))
+	return nil
+}
@@ -0,0 +1,257 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	client "github.com/threefoldtech/tfgrid-sdk-go/grid-client/node"
+)
+
+func dataSourceNode() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Data source for reading a node's live status directly from the node, to validate placement before deploying.",
+
+		ReadContext: dataSourceNodeRead,
+
+		Schema: map[string]*schema.Schema{
+			"node": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "Node ID.",
+			},
+			"zos_version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Version of zos running on the node.",
+			},
+			"zinit_version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Version of zinit running on the node.",
+			},
+			"hypervisor": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Hypervisor the node runs on, empty if it runs on bare metal.",
+			},
+			"total_resources": capacitySchema("Total capacity of the node."),
+			"used_resources":  capacitySchema("Capacity reserved on the node, including the resources reserved by zos itself."),
+			"free_resources":  capacitySchema("Capacity still free on the node."),
+			"public_config": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Public config of the node, empty if the node has no public config.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"domain": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Domain of the node.",
+						},
+						"ipv4": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Public IPv4 of the node.",
+						},
+						"ipv6": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Public IPv6 of the node.",
+						},
+						"gw4": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Gateway of the public IPv4.",
+						},
+						"gw6": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Gateway of the public IPv6.",
+						},
+					},
+				},
+			},
+			"public_ipv6": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "True if the node has a public IPv6 configuration.",
+			},
+			"public_ipv6_subnet": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Public IPv6 subnet of the node, empty if the node has no public IPv6 config.",
+			},
+			"interfaces": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Physical network interfaces of the node.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Interface name.",
+						},
+						"mac": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Interface mac address.",
+						},
+						"ips": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+							Description: "IPs assigned to the interface.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func flattenPublicConfig(cfg client.PublicConfig) []interface{} {
+	res := map[string]interface{}{
+		"domain": cfg.Domain,
+		"ipv4":   "",
+		"ipv6":   "",
+		"gw4":    "",
+		"gw6":    "",
+	}
+	if cfg.IPv4.IP != nil {
+		res["ipv4"] = cfg.IPv4.String()
+	}
+	if cfg.IPv6.IP != nil {
+		res["ipv6"] = cfg.IPv6.String()
+	}
+	if cfg.GW4 != nil {
+		res["gw4"] = cfg.GW4.String()
+	}
+	if cfg.GW6 != nil {
+		res["gw6"] = cfg.GW6.String()
+	}
+	return []interface{}{res}
+}
+
+func flattenInterfaces(interfaces map[string]client.Interface) []interface{} {
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]interface{}, 0, len(interfaces))
+	for _, name := range names {
+		res = append(res, map[string]interface{}{
+			"name": name,
+			"mac":  interfaces[name].Mac,
+			"ips":  interfaces[name].IPs,
+		})
+	}
+	return res
+}
+
+func dataSourceNodeRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	nodeID := uint32(d.Get("node").(int))
+
+	ncPool := client.NewNodeClientPool(tfPluginClient.RMB, tfPluginClient.RMBTimeout)
+	nodeClient, err := ncPool.GetNodeClient(tfPluginClient.SubstrateConn, nodeID)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "failed to get node client with ID %d", nodeID))
+	}
+
+	version, err := nodeClient.SystemVersion(ctx)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't get node %d version", nodeID))
+	}
+
+	hypervisor, err := nodeClient.SystemHypervisor(ctx)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't get node %d hypervisor", nodeID))
+	}
+
+	total, used, err := nodeClient.Statistics(ctx)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't get node %d statistics", nodeID))
+	}
+
+	hasIPv6, err := nodeClient.HasPublicIPv6(ctx)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't check node %d public ipv6", nodeID))
+	}
+
+	interfaces, err := nodeClient.NetworkListAllInterfaces(ctx)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't list node %d interfaces", nodeID))
+	}
+
+	// zos fails the call if the node has no public config
+	var publicConfig []interface{}
+	ipv6Subnet := ""
+	cfg, err := nodeClient.NetworkGetPublicConfig(ctx)
+	if err != nil {
+		log.Printf("couldn't get node %d public config: %s", nodeID, err)
+	} else {
+		publicConfig = flattenPublicConfig(cfg)
+		if cfg.IPv6.IP != nil {
+			ipv6Subnet = (&net.IPNet{IP: cfg.IPv6.IP.Mask(cfg.IPv6.Mask), Mask: cfg.IPv6.Mask}).String()
+		}
+	}
+
+	var errs error
+	if err := d.Set("zos_version", version.ZOS); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("zinit_version", version.ZInit); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("hypervisor", hypervisor); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("total_resources", flattenCapacity(total)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("used_resources", flattenCapacity(used)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("free_resources", flattenCapacity(freeCapacity(total, used))); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("public_config", publicConfig); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("public_ipv6", hasIPv6); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("public_ipv6_subnet", ipv6Subnet); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("interfaces", flattenInterfaces(interfaces)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return diag.FromErr(errors.Wrapf(errs, "couldn't set node %d status", nodeID))
+	}
+
+	d.SetId(strconv.FormatInt(time.Now().Unix(), 10))
+	return nil
+}
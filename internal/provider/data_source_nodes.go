@@ -0,0 +1,433 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	proxy "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/client"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// proxyPageSize is the page size used to list all the results of a grid proxy query
+const proxyPageSize = 100
+
+func capacitySchema(description string) *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: description,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"cru": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "Number of virtual CPUs.",
+				},
+				"mru": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "Memory size in MBs.",
+				},
+				"sru": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "Disk SSD size in MBs.",
+				},
+				"hru": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "Disk HDD size in MBs.",
+				},
+			},
+		},
+	}
+}
+
+func dataSourceNodes() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description: "Data source for listing the grid nodes matching some filters, with their capacity, public config and farm.",
+
+		ReadContext: dataSourceNodesRead,
+
+		Schema: map[string]*schema.Schema{
+			"status": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "up",
+				Description: "Node status, one of: up down standby. Empty to list nodes with any status.",
+			},
+			"country": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Country of the nodes.",
+			},
+			"city": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "City of the nodes.",
+			},
+			"farm_ids": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Description: "List of farm ids to search for nodes in.",
+			},
+			"farm_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Name of the farm to search for nodes in.",
+			},
+			"free_mru": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Minimum free memory size in MBs.",
+			},
+			"free_sru": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Minimum free disk SSD size in MBs.",
+			},
+			"free_hru": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Minimum free disk HDD size in MBs.",
+			},
+			"free_ips": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Minimum count of free public ips in the nodes' farm.",
+			},
+			"ipv4": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only nodes with (or without) a public ipv4 in their public config.",
+			},
+			"ipv6": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only nodes with (or without) a public ipv6 in their public config.",
+			},
+			"domain": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only nodes with (or without) a domain in their public config.",
+			},
+			"dedicated": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only dedicated (or shared) nodes.",
+			},
+			"rentable": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only nodes that can (or can't) be rented.",
+			},
+			"rented": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Flag to pick only rented (or not rented) nodes.",
+			},
+			"rented_by": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Twin id of the nodes' renter.",
+			},
+			"available_for": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Twin id the nodes must be available for, i.e. not rented by someone else.",
+			},
+			"certification_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Certification type of the nodes, e.g. Certified or DIY.",
+			},
+			"node_ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Description: "Ids of the matching nodes.",
+			},
+			"nodes": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "List of the matching nodes.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"node_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Node id.",
+						},
+						"farm_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Id of the node's farm.",
+						},
+						"twin_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Twin id of the node.",
+						},
+						"country": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Country of the node.",
+						},
+						"city": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "City of the node.",
+						},
+						"status": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Node status.",
+						},
+						"certification_type": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Certification type of the node.",
+						},
+						"dedicated": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "True if the node is dedicated, i.e. it has to be rented to be deployed on.",
+						},
+						"rent_contract_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Id of the node's rent contract, 0 if it's not rented.",
+						},
+						"rented_by_twin_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Twin id of the node's renter, 0 if it's not rented.",
+						},
+						"uptime": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Node uptime in seconds.",
+						},
+						"total_resources": capacitySchema("Total capacity of the node."),
+						"used_resources":  capacitySchema("Reserved capacity of the node."),
+						"free_resources":  capacitySchema("Free capacity of the node."),
+						"public_config": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "Public config of the node, empty if it has none.",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"domain": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Domain of the node, used by gateways.",
+									},
+									"ipv4": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Public ipv4 of the node.",
+									},
+									"gw4": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Gateway of the public ipv4.",
+									},
+									"ipv6": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Public ipv6 of the node.",
+									},
+									"gw6": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "Gateway of the public ipv6.",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newNodeFilterFromSchema reads the grid_nodes data source filters from schema.ResourceData.
+// Unset boolean filters aren't applied, while false ones pick the nodes lacking the feature.
+func newNodeFilterFromSchema(d *schema.ResourceData) proxyTypes.NodeFilter {
+	var f proxyTypes.NodeFilter
+
+	optionalString := func(key string) *string {
+		if v, ok := d.GetOk(key); ok {
+			s := v.(string)
+			return &s
+		}
+		return nil
+	}
+	optionalUint := func(key string, unit uint64) *uint64 {
+		if v, ok := d.GetOk(key); ok {
+			u := uint64(v.(int)) * unit
+			return &u
+		}
+		return nil
+	}
+	optionalBool := func(key string) *bool {
+		// GetOk can't tell an unset bool from a false one
+		if v, ok := d.GetOkExists(key); ok { //nolint:staticcheck
+			b := v.(bool)
+			return &b
+		}
+		return nil
+	}
+
+	f.Status = optionalString("status")
+	f.Country = optionalString("country")
+	f.City = optionalString("city")
+	f.FarmName = optionalString("farm_name")
+	for _, id := range d.Get("farm_ids").([]interface{}) {
+		f.FarmIDs = append(f.FarmIDs, uint64(id.(int)))
+	}
+	f.FreeMRU = optionalUint("free_mru", uint64(gridtypes.Megabyte))
+	f.FreeSRU = optionalUint("free_sru", uint64(gridtypes.Megabyte))
+	f.FreeHRU = optionalUint("free_hru", uint64(gridtypes.Megabyte))
+	f.FreeIPs = optionalUint("free_ips", 1)
+	f.IPv4 = optionalBool("ipv4")
+	f.IPv6 = optionalBool("ipv6")
+	f.Domain = optionalBool("domain")
+	f.Dedicated = optionalBool("dedicated")
+	f.Rentable = optionalBool("rentable")
+	f.Rented = optionalBool("rented")
+	f.RentedBy = optionalUint("rented_by", 1)
+	f.AvailableFor = optionalUint("available_for", 1)
+	return f
+}
+
+// listNodes lists all the nodes matching the filter, going through all the grid proxy pages
+func listNodes(gridProxyClient proxy.Client, filter proxyTypes.NodeFilter) ([]proxyTypes.Node, error) {
+	var nodes []proxyTypes.Node
+	for page := uint64(1); ; page++ {
+		res, _, err := gridProxyClient.Nodes(filter, proxyTypes.Limit{
+			Size: proxyPageSize,
+			Page: page,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't list nodes from the grid proxy")
+		}
+		nodes = append(nodes, res...)
+		if len(res) < proxyPageSize {
+			return nodes, nil
+		}
+	}
+}
+
+func flattenCapacity(c gridtypes.Capacity) []interface{} {
+	return []interface{}{map[string]interface{}{
+		"cru": int(c.CRU),
+		"mru": int(c.MRU / gridtypes.Megabyte),
+		"sru": int(c.SRU / gridtypes.Megabyte),
+		"hru": int(c.HRU / gridtypes.Megabyte),
+	}}
+}
+
+func proxyCapacity(c proxyTypes.Capacity) gridtypes.Capacity {
+	return gridtypes.Capacity{CRU: c.CRU, MRU: c.MRU, SRU: c.SRU, HRU: c.HRU}
+}
+
+// freeCapacity returns the unused part of the total capacity. zos only checks memory when deploying, so the used
+// cpus and disks of overprovisioned nodes can exceed their total.
+func freeCapacity(total, used gridtypes.Capacity) gridtypes.Capacity {
+	free := func(total, used uint64) uint64 {
+		if used >= total {
+			return 0
+		}
+		return total - used
+	}
+	return gridtypes.Capacity{
+		CRU: free(total.CRU, used.CRU),
+		MRU: gridtypes.Unit(free(uint64(total.MRU), uint64(used.MRU))),
+		SRU: gridtypes.Unit(free(uint64(total.SRU), uint64(used.SRU))),
+		HRU: gridtypes.Unit(free(uint64(total.HRU), uint64(used.HRU))),
+	}
+}
+
+func flattenNode(node proxyTypes.Node) map[string]interface{} {
+	total, used := proxyCapacity(node.TotalResources), proxyCapacity(node.UsedResources)
+
+	publicConfig := []interface{}{}
+	if node.PublicConfig != (proxyTypes.PublicConfig{}) {
+		publicConfig = append(publicConfig, map[string]interface{}{
+			"domain": node.PublicConfig.Domain,
+			"ipv4":   node.PublicConfig.Ipv4,
+			"gw4":    node.PublicConfig.Gw4,
+			"ipv6":   node.PublicConfig.Ipv6,
+			"gw6":    node.PublicConfig.Gw6,
+		})
+	}
+
+	return map[string]interface{}{
+		"node_id":            node.NodeID,
+		"farm_id":            node.FarmID,
+		"twin_id":            node.TwinID,
+		"country":            node.Country,
+		"city":               node.City,
+		"status":             node.Status,
+		"certification_type": node.CertificationType,
+		"dedicated":          node.Dedicated,
+		"rent_contract_id":   int(node.RentContractID),
+		"rented_by_twin_id":  int(node.RentedByTwinID),
+		"uptime":             int(node.Uptime),
+		"total_resources":    flattenCapacity(total),
+		"used_resources":     flattenCapacity(used),
+		"free_resources":     flattenCapacity(freeCapacity(total, used)),
+		"public_config":      publicConfig,
+	}
+}
+
+func dataSourceNodesRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	nodes, err := listNodes(tfPluginClient.GridProxyClient, newNodeFilterFromSchema(d))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// grid proxy doesn't support filtering nodes by certification type, so it's validated after
+	certificationType := d.Get("certification_type").(string)
+
+	nodeIDs := make([]int, 0, len(nodes))
+	flattened := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		if certificationType != "" && node.CertificationType != certificationType {
+			continue
+		}
+		nodeIDs = append(nodeIDs, node.NodeID)
+		flattened = append(flattened, flattenNode(node))
+	}
+
+	if err := d.Set("node_ids", nodeIDs); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set node ids"))
+	}
+	if err := d.Set("nodes", flattened); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set nodes"))
+	}
+
+	d.SetId(strconv.FormatInt(time.Now().Unix(), 10))
+	return nil
+}
@@ -0,0 +1,41 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+func TestFlattenNodeFreeResources(t *testing.T) {
+	cases := []struct {
+		name     string
+		total    proxyTypes.Capacity
+		used     proxyTypes.Capacity
+		expected map[string]interface{}
+	}{
+		{
+			name:     "partly used",
+			total:    proxyTypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 100 * gridtypes.Gigabyte, HRU: 1000 * gridtypes.Gigabyte},
+			used:     proxyTypes.Capacity{CRU: 2, MRU: 4 * gridtypes.Gigabyte, SRU: 25 * gridtypes.Gigabyte},
+			expected: map[string]interface{}{"cru": 6, "mru": 12 * 1024, "sru": 75 * 1024, "hru": 1000 * 1024},
+		},
+		{
+			name:     "overprovisioned",
+			total:    proxyTypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 100 * gridtypes.Gigabyte, HRU: 10 * gridtypes.Gigabyte},
+			used:     proxyTypes.Capacity{CRU: 20, MRU: 16 * gridtypes.Gigabyte, SRU: 150 * gridtypes.Gigabyte, HRU: 11 * gridtypes.Gigabyte},
+			expected: map[string]interface{}{"cru": 0, "mru": 0, "sru": 0, "hru": 0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node := flattenNode(proxyTypes.Node{TotalResources: c.total, UsedResources: c.used})
+			free := node["free_resources"].([]interface{})[0]
+			if !reflect.DeepEqual(free, c.expected) {
+				t.Fatalf("expected free resources %v, got %v", c.expected, free)
+			}
+		})
+	}
+}
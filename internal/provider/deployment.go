@@ -65,6 +65,13 @@ func newDeploymentFromSchema(d *schema.ResourceData) (*workloads.Deployment, err
 		}
 	}
 
+	// the deployer updates the contracts it finds in NodeDeploymentID and creates new ones otherwise,
+	// so existing (and imported) deployments have to be tracked there to be updated in place
+	var nodeDeploymentID map[uint32]uint64
+	if contractID != 0 {
+		nodeDeploymentID = map[uint32]uint64{nodeID: contractID}
+	}
+
 	dl := workloads.Deployment{
 		Name:             name,
 		NodeID:           nodeID,
@@ -76,6 +83,7 @@ func newDeploymentFromSchema(d *schema.ResourceData) (*workloads.Deployment, err
 		Zdbs:             zdbs,
 		NetworkName:      networkName,
 		ContractID:       contractID,
+		NodeDeploymentID: nodeDeploymentID,
 	}
 
 	return &dl, nil
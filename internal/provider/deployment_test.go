@@ -0,0 +1,46 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestNewDeploymentFromSchemaContract(t *testing.T) {
+	cases := []struct {
+		name             string
+		id               string
+		contractID       uint64
+		nodeDeploymentID map[uint32]uint64
+	}{
+		{"new deployment creates a contract", "", 0, nil},
+		{"existing deployment updates its contract", "12", 12, map[uint32]uint64{3: 12}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{
+				"node": 3,
+			})
+			d.SetId(c.id)
+
+			dl, err := newDeploymentFromSchema(d)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if dl.ContractID != c.contractID {
+				t.Fatalf("expected contract id %d, got %d", c.contractID, dl.ContractID)
+			}
+			if !reflect.DeepEqual(dl.NodeDeploymentID, c.nodeDeploymentID) {
+				t.Fatalf("expected node deployment ids %v, got %v", c.nodeDeploymentID, dl.NodeDeploymentID)
+			}
+		})
+	}
+
+	d := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{"node": 3})
+	d.SetId("contract")
+	if _, err := newDeploymentFromSchema(d); err == nil {
+		t.Fatal("an invalid id should fail")
+	}
+}
@@ -0,0 +1,125 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// importID is the parsed form of the `name=<name>;contracts=<id>,<id>,...` import id used by resources spanning
+// multiple node contracts.
+type importID struct {
+	name      string
+	contracts []uint64
+}
+
+func parseImportID(id string) (importID, error) {
+	var res importID
+	for _, part := range strings.Split(id, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return importID{}, fmt.Errorf("invalid import id part '%s', expected key=value", part)
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			res.name = strings.TrimSpace(value)
+		case "contracts":
+			for _, c := range strings.Split(value, ",") {
+				contractID, err := strconv.ParseUint(strings.TrimSpace(c), 10, 64)
+				if err != nil {
+					return importID{}, errors.Wrapf(err, "couldn't parse contract id '%s'", c)
+				}
+				res.contracts = append(res.contracts, contractID)
+			}
+		default:
+			return importID{}, fmt.Errorf("unknown import id key '%s'", key)
+		}
+	}
+
+	if res.name == "" {
+		return importID{}, fmt.Errorf("import id '%s' is missing the name, expected name=<name>;contracts=<id>,<id>", id)
+	}
+	if len(res.contracts) == 0 {
+		return importID{}, fmt.Errorf("import id '%s' is missing the contracts, expected name=<name>;contracts=<id>,<id>", id)
+	}
+	return res, nil
+}
+
+// loadNodeContractDeployment validates that the contract is a live node contract of the current twin, then fetches
+// its deployment from the node.
+func loadNodeContractDeployment(ctx context.Context, tfPluginClient *deployer.TFPluginClient, contractID uint64) (subi.Contract, gridtypes.Deployment, error) {
+	contract, err := tfPluginClient.SubstrateConn.GetContract(contractID)
+	if err != nil {
+		return subi.Contract{}, gridtypes.Deployment{}, errors.Wrapf(err, "couldn't get contract %d", contractID)
+	}
+	if !contract.ContractType.IsNodeContract {
+		return subi.Contract{}, gridtypes.Deployment{}, fmt.Errorf("contract %d is not a node contract", contractID)
+	}
+	if contract.IsDeleted() {
+		return subi.Contract{}, gridtypes.Deployment{}, fmt.Errorf("contract %d is deleted", contractID)
+	}
+	if contract.TwinID() != tfPluginClient.TwinID {
+		return subi.Contract{}, gridtypes.Deployment{}, fmt.Errorf("contract %d is not owned by twin %d", contractID, tfPluginClient.TwinID)
+	}
+
+	nodeID := uint32(contract.ContractType.NodeContract.Node)
+	nodeClient, err := tfPluginClient.NcPool.GetNodeClient(tfPluginClient.SubstrateConn, nodeID)
+	if err != nil {
+		return subi.Contract{}, gridtypes.Deployment{}, errors.Wrapf(err, "failed to get node client with ID %d", nodeID)
+	}
+
+	dl, err := nodeClient.DeploymentGet(ctx, contractID)
+	if err != nil {
+		return subi.Contract{}, gridtypes.Deployment{}, errors.Wrapf(err, "couldn't get deployment %d from node %d", contractID, nodeID)
+	}
+
+	return contract, dl, nil
+}
+
+// loadNodesDeployments loads the deployments of the given contracts, keyed by their node id.
+// Only one deployment per node is allowed, as in networks and kubernetes clusters.
+func loadNodesDeployments(ctx context.Context, tfPluginClient *deployer.TFPluginClient, contracts []uint64) (map[uint32]gridtypes.Deployment, error) {
+	deployments := make(map[uint32]gridtypes.Deployment)
+	for _, contractID := range contracts {
+		contract, dl, err := loadNodeContractDeployment(ctx, tfPluginClient, contractID)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeID := uint32(contract.ContractType.NodeContract.Node)
+		if existing, ok := deployments[nodeID]; ok {
+			return nil, fmt.Errorf("contracts %d and %d are both on node %d", existing.ContractID, contractID, nodeID)
+		}
+		deployments[nodeID] = dl
+	}
+	return deployments, nil
+}
+
+// loadGatewayWorkload loads the workload of a gateway import id, which must list exactly one node contract.
+func loadGatewayWorkload(ctx context.Context, tfPluginClient *deployer.TFPluginClient, id importID) (uint32, gridtypes.Deployment, gridtypes.Workload, error) {
+	if len(id.contracts) != 1 {
+		return 0, gridtypes.Deployment{}, gridtypes.Workload{}, fmt.Errorf("a gateway is deployed on exactly one node contract, got %d", len(id.contracts))
+	}
+
+	contract, dl, err := loadNodeContractDeployment(ctx, tfPluginClient, id.contracts[0])
+	if err != nil {
+		return 0, gridtypes.Deployment{}, gridtypes.Workload{}, err
+	}
+
+	wl, err := dl.Get(gridtypes.Name(id.name))
+	if err != nil {
+		return 0, gridtypes.Deployment{}, gridtypes.Workload{}, errors.Wrapf(err, "couldn't find gateway %s in contract %d", id.name, dl.ContractID)
+	}
+	if !wl.Result.State.IsOkay() {
+		return 0, gridtypes.Deployment{}, gridtypes.Workload{}, fmt.Errorf("gateway %s in contract %d is in state %s", id.name, dl.ContractID, wl.Result.State)
+	}
+
+	return uint32(contract.ContractType.NodeContract.Node), dl, *wl.Workload, nil
+}
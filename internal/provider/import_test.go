@@ -0,0 +1,150 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+func TestParseImportID(t *testing.T) {
+	cases := []struct {
+		id       string
+		expected importID
+		err      bool
+	}{
+		{"name=net1;contracts=12", importID{name: "net1", contracts: []uint64{12}}, false},
+		{"name=net1;contracts=12,13,14", importID{name: "net1", contracts: []uint64{12, 13, 14}}, false},
+		{" contracts = 12, 13 ; name = net1 ", importID{name: "net1", contracts: []uint64{12, 13}}, false},
+		{"name=net1", importID{}, true},
+		{"contracts=12", importID{}, true},
+		{"name=net1;contracts=a", importID{}, true},
+		{"name=net1;contracts=12,", importID{}, true},
+		{"name=net1;contracts=-1", importID{}, true},
+		{"name=net1;node=12", importID{}, true},
+		{"net1;12", importID{}, true},
+		{"", importID{}, true},
+	}
+	for _, c := range cases {
+		id, err := parseImportID(c.id)
+		if c.err != (err != nil) {
+			t.Fatalf("import id %q: expected error %t, got %v", c.id, c.err, err)
+		}
+		if !reflect.DeepEqual(id, c.expected) {
+			t.Fatalf("import id %q: expected %+v, got %+v", c.id, c.expected, id)
+		}
+	}
+}
+
+func k8sDeployment(contractID uint64, vms map[string]map[string]string) gridtypes.Deployment {
+	dl := gridtypes.Deployment{
+		ContractID: contractID,
+		Metadata:   `{"type":"kubernetes","name":"k8s1","projectName":"Kubernetes"}`,
+	}
+	for name, env := range vms {
+		dl.Workloads = append(dl.Workloads, gridtypes.Workload{
+			Name: gridtypes.Name(name),
+			Type: zos.ZMachineType,
+			Data: gridtypes.MustMarshal(zos.ZMachine{Env: env}),
+		})
+	}
+	dl.Workloads = append(dl.Workloads, gridtypes.Workload{
+		Name: "disk",
+		Type: zos.ZMountType,
+		Data: gridtypes.MustMarshal(zos.ZMount{Size: gridtypes.Gigabyte}),
+	})
+	return dl
+}
+
+func TestFindK8sMaster(t *testing.T) {
+	worker := map[string]string{"K3S_URL": "https://10.1.2.2:6443"}
+	master := map[string]string{"K3S_TOKEN": "token"}
+
+	cases := []struct {
+		name        string
+		deployments map[uint32]gridtypes.Deployment
+		expected    string
+	}{
+		{"master with workers on the same node", map[uint32]gridtypes.Deployment{
+			1: k8sDeployment(10, map[string]map[string]string{"master": master, "w1": worker}),
+		}, "master"},
+		{"master and workers on different nodes", map[uint32]gridtypes.Deployment{
+			1: k8sDeployment(10, map[string]map[string]string{"w1": worker}),
+			2: k8sDeployment(11, map[string]map[string]string{"master": master}),
+			3: k8sDeployment(12, map[string]map[string]string{"w2": worker}),
+		}, "master"},
+		{"only workers", map[uint32]gridtypes.Deployment{
+			1: k8sDeployment(10, map[string]map[string]string{"w1": worker}),
+		}, ""},
+		{"multiple masters", map[uint32]gridtypes.Deployment{
+			1: k8sDeployment(10, map[string]map[string]string{"m1": master}),
+			2: k8sDeployment(11, map[string]map[string]string{"m2": master}),
+		}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, solutionType, err := findK8sMaster(c.deployments)
+			if c.expected == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got master %s", node.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if node.Name != c.expected || solutionType != "Kubernetes" {
+				t.Fatalf("expected master %s of Kubernetes, got %s of %s", c.expected, node.Name, solutionType)
+			}
+		})
+	}
+}
+
+func TestVMSubnet(t *testing.T) {
+	cases := []struct {
+		ip       string
+		expected string
+	}{
+		{"10.1.2.5", "10.1.2.0/24"},
+		{"10.20.3.254", "10.20.3.0/24"},
+		{"", ""},
+		{"vm", ""},
+		{"fd00::1", ""},
+	}
+	for _, c := range cases {
+		subnet, err := vmSubnet(c.ip)
+		if c.expected == "" {
+			if err == nil {
+				t.Fatalf("ip %q: expected an error, got %s", c.ip, subnet)
+			}
+			continue
+		}
+		if err != nil || subnet != c.expected {
+			t.Fatalf("ip %q: expected %s, got %s (%v)", c.ip, c.expected, subnet, err)
+		}
+	}
+}
+
+func TestExternalNetworkPeer(t *testing.T) {
+	subnet := func(s string) gridtypes.IPNet {
+		ipNet, err := gridtypes.ParseIPNet(s)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return ipNet
+	}
+	nodes := map[uint32]gridtypes.IPNet{1: subnet("10.1.2.0/24"), 2: subnet("10.1.3.0/24")}
+
+	peers := []zos.Peer{{Subnet: subnet("10.1.3.0/24")}, {Subnet: subnet("10.1.2.0/24")}}
+	if peer := externalNetworkPeer(nodes, peers); peer != nil {
+		t.Fatalf("peers between the nodes aren't external, got %s", peer.Subnet)
+	}
+
+	peers = append(peers, zos.Peer{Subnet: subnet("10.1.4.0/24")})
+	peer := externalNetworkPeer(nodes, peers)
+	if peer == nil || peer.Subnet.String() != "10.1.4.0/24" {
+		t.Fatalf("expected the wireguard access peer, got %v", peer)
+	}
+}
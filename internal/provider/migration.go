@@ -0,0 +1,187 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+// migrationHookOutputLimit is the count of bytes of the hook output kept in the diagnostics
+const migrationHookOutputLimit = 4096
+
+// migrateDeployment moves a deployment to a new node without dropping its data. The deployment is created on the new
+// node first, then the migration hook copies the data from the old workloads to the new ones, and the old contract is
+// only canceled once both succeeded. On failure the new contract is canceled and the resource keeps the old deployment.
+func migrateDeployment(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	oldContractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("couldn't parse deployment id %s with error: %v", d.Id(), err)
+	}
+	oldNode, newNode := d.GetChange("node")
+	oldNodeID, newNodeID := uint32(oldNode.(int)), uint32(newNode.(int))
+
+	// nothing is changed on failure, the resource has to keep pointing to the old deployment
+	d.Partial(true)
+
+	_, zosDeployment, err := loadNodeContractDeployment(ctx, tfPluginClient, oldContractID)
+	if err != nil {
+		return diag.Errorf("couldn't load the deployment to migrate from node %d with error: %v", oldNodeID, err)
+	}
+	oldDl, err := workloads.NewDeploymentFromZosDeployment(zosDeployment, oldNodeID)
+	if err != nil {
+		return diag.Errorf("couldn't load the deployment to migrate from node %d with error: %v", oldNodeID, err)
+	}
+
+	dl, err := newDeploymentFromSchema(d)
+	if err != nil {
+		return diag.Errorf("couldn't load deployment data with error: %v", err)
+	}
+	dl.ContractID = 0
+	dl.NodeDeploymentID = nil
+
+	rollback := func(summary string, cause error) diag.Diagnostics {
+		detail := fmt.Sprintf("%v\n\nThe deployment is kept on node %d with contract %d.", cause, oldNodeID, oldContractID)
+		if dl.ContractID != 0 {
+			newContractID := dl.ContractID
+			if err := tfPluginClient.DeploymentDeployer.Cancel(ctx, dl); err != nil {
+				detail += fmt.Sprintf(" Rolling back failed, contract %d on node %d couldn't be canceled and has to be canceled manually: %v", newContractID, newNodeID, err)
+			} else {
+				detail += fmt.Sprintf(" Contract %d on node %d was canceled.", newContractID, newNodeID)
+			}
+		}
+		return append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  summary,
+			Detail:   detail,
+		})
+	}
+
+	// deploy waits for the workloads to be ready, the new deployment is healthy once it returns
+	if err := tfPluginClient.DeploymentDeployer.Deploy(ctx, dl); err != nil {
+		return rollback(fmt.Sprintf("couldn't migrate deployment to node %d", newNodeID), err)
+	}
+	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+		return rollback(fmt.Sprintf("couldn't migrate deployment to node %d", newNodeID), errors.Wrap(err, "couldn't sync the new deployment"))
+	}
+
+	if hook := d.Get("migration_hook").(string); hook != "" {
+		env, err := migrationHookEnv(&oldDl, dl)
+		if err != nil {
+			return rollback(fmt.Sprintf("couldn't migrate deployment data to node %d", newNodeID), err)
+		}
+		output, err := runMigrationHook(ctx, hook, env)
+		if err != nil {
+			return rollback(
+				fmt.Sprintf("couldn't migrate deployment data to node %d", newNodeID),
+				errors.Wrapf(err, "migration hook failed with output:\n%s", output),
+			)
+		}
+		log.Printf("migration hook of deployment %d output: %s", oldContractID, output)
+	}
+
+	// the new deployment holds the data from now on
+	d.Partial(false)
+	if err := cancelMigratedDeployment(ctx, tfPluginClient, dl.NetworkName, oldNodeID, oldContractID); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("couldn't cancel contract %d on node %d after migrating", oldContractID, oldNodeID),
+			Detail:   fmt.Sprintf("The deployment was migrated to node %d, the old contract has to be canceled manually: %v", newNodeID, err),
+		})
+	}
+
+	if err := syncContractsDeployments(d, dl); err != nil {
+		return append(diags, diag.Errorf("couldn't set deployment data to the resource with error: %v", err)...)
+	}
+
+	return diags
+}
+
+// cancelMigratedDeployment cancels the old contract of a migrated deployment through the deployer, so it's dropped
+// from the current deployments of the node, and releases the private ips the old vms held in the network state
+func cancelMigratedDeployment(ctx context.Context, tfPluginClient *deployer.TFPluginClient, networkName string, nodeID uint32, contractID uint64) error {
+	// the old workloads aren't needed to cancel the contract
+	oldDl := &workloads.Deployment{
+		NodeID:           nodeID,
+		ContractID:       contractID,
+		NodeDeploymentID: map[uint32]uint64{nodeID: contractID},
+	}
+	if err := tfPluginClient.DeploymentDeployer.Cancel(ctx, oldDl); err != nil {
+		return err
+	}
+
+	if network, ok := tfPluginClient.State.Networks[networkName]; ok {
+		network.DeleteDeploymentHostIDs(nodeID, contractID)
+	}
+	return nil
+}
+
+// migrationHookEnv describes both deployments to the migration hook: the node and contract ids, and the workloads in
+// the same format as the resource attributes, with the ips to reach the vms and zdbs.
+func migrationHookEnv(oldDl, newDl *workloads.Deployment) ([]string, error) {
+	oldWorkloads, err := json.Marshal(migrationWorkloads(oldDl))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't encode the old workloads")
+	}
+	newWorkloads, err := json.Marshal(migrationWorkloads(newDl))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't encode the new workloads")
+	}
+
+	return []string{
+		fmt.Sprintf("GRID_MIGRATION_OLD_NODE=%d", oldDl.NodeID),
+		fmt.Sprintf("GRID_MIGRATION_NEW_NODE=%d", newDl.NodeID),
+		fmt.Sprintf("GRID_MIGRATION_OLD_CONTRACT=%d", oldDl.ContractID),
+		fmt.Sprintf("GRID_MIGRATION_NEW_CONTRACT=%d", newDl.ContractID),
+		fmt.Sprintf("GRID_MIGRATION_OLD_WORKLOADS=%s", oldWorkloads),
+		fmt.Sprintf("GRID_MIGRATION_NEW_WORKLOADS=%s", newWorkloads),
+	}, nil
+}
+
+func migrationWorkloads(dl *workloads.Deployment) map[string]interface{} {
+	vms := make([]interface{}, 0, len(dl.Vms))
+	for _, vm := range dl.Vms {
+		vmMap := vm.ToMap()
+		delete(vmMap, "network_name")
+		vms = append(vms, vmMap)
+	}
+	disks := make([]interface{}, 0, len(dl.Disks))
+	for _, disk := range dl.Disks {
+		disks = append(disks, disk.ToMap())
+	}
+	zdbs := make([]interface{}, 0, len(dl.Zdbs))
+	for _, zdb := range dl.Zdbs {
+		zdbs = append(zdbs, zdb.ToMap())
+	}
+	return map[string]interface{}{
+		"vms":   vms,
+		"disks": disks,
+		"zdbs":  zdbs,
+	}
+}
+
+// runMigrationHook runs the hook command with the migration environment on the machine running terraform
+func runMigrationHook(ctx context.Context, hook string, env []string) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+
+	out := strings.TrimSpace(string(output))
+	if len(out) > migrationHookOutputLimit {
+		out = "..." + out[len(out)-migrationHookOutputLimit:]
+	}
+	return out, err
+}
@@ -0,0 +1,91 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+// fakeCanceler is a substrate connection of a funded account recording the canceled contracts
+type fakeCanceler struct {
+	subi.SubstrateExt
+	canceled []uint64
+}
+
+func (f *fakeCanceler) GetBalance(identity substrate.Identity) (substrate.Balance, error) {
+	return substrate.Balance{Free: types.NewU128(*big.NewInt(1_000_000))}, nil
+}
+
+func (f *fakeCanceler) EnsureContractCanceled(identity substrate.Identity, contractID uint64) error {
+	f.canceled = append(f.canceled, contractID)
+	return nil
+}
+
+func TestMigrationHook(t *testing.T) {
+	oldDl := &workloads.Deployment{
+		NodeID:     1,
+		ContractID: 10,
+		Zdbs:       []workloads.ZDB{{Name: "zdb", Namespace: "ns", Port: 9900, IPs: []string{"10.1.2.3"}}},
+	}
+	newDl := &workloads.Deployment{
+		NodeID:     2,
+		ContractID: 20,
+		Zdbs:       []workloads.ZDB{{Name: "zdb", Namespace: "ns2", Port: 9900, IPs: []string{"10.1.3.3"}}},
+	}
+	env, err := migrationHookEnv(oldDl, newDl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	output, err := runMigrationHook(context.Background(), `echo "$GRID_MIGRATION_OLD_NODE $GRID_MIGRATION_NEW_CONTRACT" && echo "$GRID_MIGRATION_NEW_WORKLOADS" | grep -q '"namespace":"ns2"'`, env)
+	if err != nil {
+		t.Fatalf("err: %s, output: %s", err, output)
+	}
+	if output != "1 20" {
+		t.Fatalf("unexpected hook output %q", output)
+	}
+
+	if _, err := runMigrationHook(context.Background(), "exit 3", env); err == nil {
+		t.Fatal("a failing hook should return an error")
+	}
+}
+
+func TestCancelMigratedDeployment(t *testing.T) {
+	sub := &fakeCanceler{}
+	tfPluginClient := &deployer.TFPluginClient{SubstrateConn: sub}
+	tfPluginClient.State = state.NewState(nil, sub)
+	tfPluginClient.DeploymentDeployer = deployer.NewDeploymentDeployer(tfPluginClient)
+
+	// contract 10 on node 1 was migrated to contract 20 on node 2, contract 11 is another deployment on node 1
+	tfPluginClient.State.CurrentNodeDeployments = map[uint32]state.ContractIDs{1: {10, 11}, 2: {20}}
+	network := tfPluginClient.State.Networks.GetNetwork("net1")
+	network.SetDeploymentHostIDs(1, 10, []byte{2})
+	network.SetDeploymentHostIDs(1, 11, []byte{3})
+	network.SetDeploymentHostIDs(2, 20, []byte{2})
+
+	if err := cancelMigratedDeployment(context.Background(), tfPluginClient, "net1", 1, 10); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(sub.canceled, []uint64{10}) {
+		t.Fatalf("expected contract 10 to be canceled, got %v", sub.canceled)
+	}
+	expected := map[uint32]state.ContractIDs{1: {11}, 2: {20}}
+	if !reflect.DeepEqual(tfPluginClient.State.CurrentNodeDeployments, expected) {
+		t.Fatalf("expected current deployments %v, got %v", expected, tfPluginClient.State.CurrentNodeDeployments)
+	}
+	if ids := network.GetUsedNetworkHostIDs(1); !reflect.DeepEqual(ids, []byte{3}) {
+		t.Fatalf("expected the host ids of contract 10 to be released, got %v", ids)
+	}
+	if ids := network.GetUsedNetworkHostIDs(2); !reflect.DeepEqual(ids, []byte{2}) {
+		t.Fatalf("expected the host ids of the new contract to be kept, got %v", ids)
+	}
+}
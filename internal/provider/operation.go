@@ -0,0 +1,90 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	gridstate "github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+
+	"github.com/threefoldtech/terraform-provider-grid/internal/state"
+)
+
+// operation is an operation of a resource working on its own copy of the network state, so the operations of the
+// resources run in parallel without sharing the state maps. Its changes are saved on top of the current state once
+// it's done.
+type operation struct {
+	st *state.Store
+	// base is the network state the operation started from
+	base gridstate.NetworkState
+	// client is a copy of the plugin client whose deployers work on the operation's network state
+	client *deployer.TFPluginClient
+	// reservation is the key of the host ids reserved for the operation, zero if it didn't reserve any
+	reservation uint64
+}
+
+// beginOperation reserves the host ids of the resource if it deploys vms, then copies the network state for the operation
+func beginOperation(ctx context.Context, st *state.Store, d *schema.ResourceData, meta interface{}, reserve hostIDsReserver) (*operation, error) {
+	op := operation{st: st}
+	if reserve != nil {
+		op.reservation = newReservationKey()
+		err := st.Update(ctx, func(networks gridstate.NetworkState) error {
+			return reserve(d, networks, op.reservation)
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to reserve the private ips")
+		}
+	}
+
+	op.base = st.Networks()
+	if tfPluginClient, ok := meta.(*deployer.TFPluginClient); ok {
+		op.client = operationClient(tfPluginClient, state.CopyNetworks(op.base))
+	}
+	return &op, nil
+}
+
+// meta is the provider meta passed to the operation
+func (op *operation) meta(meta interface{}) interface{} {
+	if op.client == nil {
+		return meta
+	}
+	return op.client
+}
+
+// commit saves the changes of the operation and releases its reservation, under the state lock
+func (op *operation) commit(ctx context.Context) error {
+	var changed gridstate.NetworkState
+	if op.client != nil && !reflect.DeepEqual(op.base, op.client.State.Networks) {
+		changed = op.client.State.Networks
+	}
+	if changed == nil && op.reservation == 0 {
+		return nil
+	}
+
+	return op.st.Update(ctx, func(networks gridstate.NetworkState) error {
+		if changed != nil {
+			state.ApplyChanges(networks, op.base, changed)
+		}
+		if op.reservation != 0 {
+			releaseHostIDs(networks, op.reservation)
+		}
+		return nil
+	})
+}
+
+// operationClient returns a copy of the plugin client whose deployers work on their own network state
+func operationClient(tfPluginClient *deployer.TFPluginClient, networks gridstate.NetworkState) *deployer.TFPluginClient {
+	client := *tfPluginClient
+	client.State = gridstate.NewState(client.NcPool, client.SubstrateConn)
+	client.State.Networks = networks
+
+	client.DeploymentDeployer = deployer.NewDeploymentDeployer(&client)
+	client.NetworkDeployer = deployer.NewNetworkDeployer(&client)
+	client.GatewayFQDNDeployer = deployer.NewGatewayFqdnDeployer(&client)
+	client.GatewayNameDeployer = deployer.NewGatewayNameDeployer(&client)
+	client.K8sDeployer = deployer.NewK8sDeployer(&client)
+	return &client
+}
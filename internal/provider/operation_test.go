@@ -0,0 +1,52 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+
+	"github.com/threefoldtech/terraform-provider-grid/internal/state"
+)
+
+func TestParallelOperations(t *testing.T) {
+	ctx := context.Background()
+	st := state.NewStore()
+	tfPluginClient := &deployer.TFPluginClient{}
+
+	first, err := beginOperation(ctx, st, nil, tfPluginClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := beginOperation(ctx, st, nil, tfPluginClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each operation deploys on its own copy of the state
+	network := first.client.State.Networks.GetNetwork("net1")
+	network.SetDeploymentHostIDs(1, 10, []byte{2})
+	network = second.client.State.Networks.GetNetwork("net1")
+	network.SetDeploymentHostIDs(1, 20, []byte{3})
+	if len(network.NodeDeploymentHostIDs[1]) != 1 {
+		t.Fatalf("expected the operations not to share their state, got %v", network.NodeDeploymentHostIDs[1])
+	}
+	if first.client.DeploymentDeployer == second.client.DeploymentDeployer {
+		t.Fatalf("expected the operations to have their own deployers")
+	}
+
+	if err := second.commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	network = st.Networks().GetNetwork("net1")
+	expected := map[uint64][]byte{10: {2}, 20: {3}}
+	if !reflect.DeepEqual(map[uint64][]byte(network.NodeDeploymentHostIDs[1]), expected) {
+		t.Fatalf("expected host ids %v, got %v", expected, network.NodeDeploymentHostIDs[1])
+	}
+}
@@ -3,6 +3,7 @@ package provider
 
 import (
 	"context"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
@@ -52,7 +53,7 @@ func init() {
 }
 
 // New returns a new schema.Provider instance, and an open substrate connection
-func New(version string, st state.Getter) (func() *schema.Provider, subi.SubstrateExt) {
+func New(version string, st *state.Store) (func() *schema.Provider, subi.SubstrateExt) {
 	var substrateConnection subi.SubstrateExt
 	return func() *schema.Provider {
 		p := &schema.Provider{
@@ -116,19 +117,65 @@ func New(version string, st state.Getter) (func() *schema.Provider, subi.Substra
 					Description: "timeout duration in seconds for rmb calls",
 					DefaultFunc: schema.EnvDefaultFunc("RMB_TIMEOUT", 10),
 				},
+				"state_backend": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "backend storing the provider's network state, one of: file http redis",
+					DefaultFunc: schema.EnvDefaultFunc("STATE_BACKEND", state.FileBackend),
+				},
+				"state_path": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "path of the network state file for the file backend",
+					DefaultFunc: schema.EnvDefaultFunc("STATE_PATH", state.FileName),
+				},
+				"state_url": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "address of the http backend (e.g. https://example.com/state), or url of the redis backend (e.g. redis://localhost:6379/0)",
+					DefaultFunc: schema.EnvDefaultFunc("STATE_URL", nil),
+				},
+				"state_key": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "key of the network state for the redis backend",
+					DefaultFunc: schema.EnvDefaultFunc("STATE_KEY", state.DefaultRedisKey),
+				},
+				"state_lock_timeout": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Description: "timeout duration in seconds for waiting on the network state lock, held by another run while it reloads, changes and saves the state",
+					DefaultFunc: schema.EnvDefaultFunc("STATE_LOCK_TIMEOUT", 60),
+				},
+				"reconcile_state": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Description: "whether to prune the network state host ids of contracts cancelled outside terraform, and report conflicting allocations, each time the provider is configured (including plans)",
+					DefaultFunc: schema.EnvDefaultFunc("RECONCILE_STATE", false),
+				},
 			},
 			DataSourcesMap: map[string]*schema.Resource{
 				"grid_gateway_domain": dataSourceGatewayDomain(),
+				"grid_account":        dataSourceAccount(),
+				"grid_node":           dataSourceNode(),
+				"grid_nodes":          dataSourceNodes(),
+				"grid_farms":          dataSourceFarms(),
+				"grid_contracts":      dataSourceContracts(),
 			},
 			ResourcesMap: map[string]*schema.Resource{
-				"grid_scheduler":  resourceScheduler(),
-				"grid_deployment": resourceDeployment(),
-				"grid_network":    resourceNetwork(),
-				"grid_kubernetes": resourceKubernetes(),
-				"grid_name_proxy": resourceGatewayNameProxy(),
-				"grid_fqdn_proxy": resourceGatewayFQDNProxy(),
+				"grid_scheduler":     resourceScheduler(),
+				"grid_deployment":    resourceDeployment(),
+				"grid_network":       resourceNetwork(),
+				"grid_kubernetes":    resourceKubernetes(),
+				"grid_name_proxy":    resourceGatewayNameProxy(),
+				"grid_fqdn_proxy":    resourceGatewayFQDNProxy(),
+				"grid_rent_contract": resourceRentContract(),
 			},
 		}
+		for name, r := range p.ResourcesMap {
+			persistState(st, r, hostIDsReservers[name])
+		}
+
 		configFunc, sub := providerConfigure(st)
 		substrateConnection = sub
 		p.ConfigureContextFunc = configFunc
@@ -137,7 +184,7 @@ func New(version string, st state.Getter) (func() *schema.Provider, subi.Substra
 	}, substrateConnection
 }
 
-func providerConfigure(st state.Getter) (func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics), subi.SubstrateExt) {
+func providerConfigure(st *state.Store) (func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics), subi.SubstrateExt) {
 	var substrateConn subi.SubstrateExt
 	return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 		mnemonics := d.Get("mnemonics").(string)
@@ -159,9 +206,76 @@ func providerConfigure(st state.Getter) (func(ctx context.Context, d *schema.Res
 			return nil, diag.FromErr(errors.Wrap(err, "error creating threefold plugin client"))
 		}
 
+		backend, err := state.NewBackend(state.Config{
+			Backend: d.Get("state_backend").(string),
+			Path:    d.Get("state_path").(string),
+			URL:     d.Get("state_url").(string),
+			Key:     d.Get("state_key").(string),
+		})
+		if err != nil {
+			return nil, diag.FromErr(errors.Wrap(err, "error creating state backend"))
+		}
+
+		lockTimeout := time.Duration(d.Get("state_lock_timeout").(int)) * time.Second
+		if err := st.Open(ctx, backend, lockTimeout); err != nil {
+			return nil, diag.FromErr(errors.Wrap(err, "error opening state backend"))
+		}
+
 		// set state
 		tfPluginClient.State.Networks = st.GetState().Networks
 
-		return &tfPluginClient, nil
+		var diags diag.Diagnostics
+		if d.Get("reconcile_state").(bool) {
+			diags = reconcileNetworkState(ctx, st, &tfPluginClient)
+		}
+
+		return &tfPluginClient, diags
 	}, substrateConn
 }
+
+// persistState saves the network state after each operation of the resource, so the allocations of a run survive it getting killed.
+// Each operation works on its own copy of the network state and only holds the state lock to reserve its private ips
+// and to save its changes, so the operations of the resources run in parallel.
+func persistState(st *state.Store, r *schema.Resource, reserve hostIDsReserver) {
+	wrap := func(fn schema.CreateContextFunc, reserve hostIDsReserver) schema.CreateContextFunc {
+		if fn == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			op, err := beginOperation(ctx, st, d, meta, reserve)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+
+			diags := fn(ctx, d, op.meta(meta))
+			if err := op.commit(ctx); err != nil {
+				diags = append(diags, diag.Diagnostic{
+					Severity: diag.Warning,
+					Summary:  "failed to save network state",
+					Detail:   err.Error(),
+				})
+			}
+			return diags
+		}
+	}
+	r.CreateContext = wrap(r.CreateContext, reserve)
+	r.ReadContext = schema.ReadContextFunc(wrap(schema.CreateContextFunc(r.ReadContext), nil))
+	r.UpdateContext = schema.UpdateContextFunc(wrap(schema.CreateContextFunc(r.UpdateContext), reserve))
+	r.DeleteContext = schema.DeleteContextFunc(wrap(schema.CreateContextFunc(r.DeleteContext), nil))
+
+	if r.Importer != nil && r.Importer.StateContext != nil {
+		importer := r.Importer.StateContext
+		r.Importer.StateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+			op, err := beginOperation(ctx, st, d, meta, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := importer(ctx, d, op.meta(meta))
+			if err != nil {
+				return nil, err
+			}
+			return data, errors.Wrap(op.commit(ctx), "failed to save network state")
+		}
+	}
+}
@@ -8,8 +8,7 @@ import (
 )
 
 func TestProvider(t *testing.T) {
-	stateDB := state.NewLocalFileState()
-	f, sub := New("dev", &stateDB)
+	f, sub := New("dev", state.NewStore())
 	if sub != nil {
 		defer sub.Close()
 	}
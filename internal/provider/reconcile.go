@@ -0,0 +1,137 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+
+	providerState "github.com/threefoldtech/terraform-provider-grid/internal/state"
+)
+
+// reconcileNetworkState prunes the host ids of deployments whose contracts were cancelled outside terraform,
+// and reports the allocations conflicting with the twin's live contracts.
+//
+// The live contracts are listed from graphql, which lags behind the chain, so a contract missing from the list is
+// only pruned once the chain confirms it's deleted.
+func reconcileNetworkState(ctx context.Context, st *providerState.Store, tfPluginClient *deployer.TFPluginClient) (diags diag.Diagnostics) {
+	contracts, err := tfPluginClient.ContractsGetter.ListContractsByTwinID([]string{"Created, GracePeriod"})
+	if err != nil {
+		return diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  "couldn't reconcile network state",
+			Detail:   errors.Wrap(err, "failed to list twin contracts").Error(),
+		}}
+	}
+
+	liveContracts := make(map[uint64]uint32)
+	for _, contract := range contracts.NodeContracts {
+		contractID, err := strconv.ParseUint(contract.ContractID, 10, 64)
+		if err != nil {
+			log.Printf("couldn't parse contract id %s: %s", contract.ContractID, err)
+			continue
+		}
+		liveContracts[contractID] = contract.NodeID
+	}
+
+	err = st.Update(ctx, func(networks state.NetworkState) error {
+		diags = reconcileNetworks(networks, liveContracts, tfPluginClient.SubstrateConn, time.Now())
+		return nil
+	})
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "couldn't reconcile network state",
+			Detail:   err.Error(),
+		})
+	}
+	return diags
+}
+
+// reconcileNetworks reconciles the networks host ids with the live contracts of the twin, mapped to their nodes.
+// The host ids reserved by running operations are kept, the stale reservations of crashed runs are pruned.
+func reconcileNetworks(networks state.NetworkState, liveContracts map[uint64]uint32, sub subi.SubstrateExt, now time.Time) (diags diag.Diagnostics) {
+	var conflicts []string
+	for name, network := range networks {
+		for nodeID, deployments := range network.NodeDeploymentHostIDs {
+			// host id to the contracts using it on the node
+			hostIDs := make(map[byte][]uint64)
+
+			for contractID, ids := range deployments {
+				if isReservation(contractID) {
+					if isStaleReservation(contractID, now) {
+						log.Printf("network %s: pruning host ids %v of a stale reservation on node %d", name, ids, nodeID)
+						network.DeleteDeploymentHostIDs(nodeID, contractID)
+					}
+					continue
+				}
+
+				liveNode, live := liveContracts[contractID]
+				if live {
+					if liveNode != nodeID {
+						conflicts = append(conflicts, fmt.Sprintf("network %s: contract %d is recorded on node %d but is deployed on node %d", name, contractID, nodeID, liveNode))
+					}
+					for _, id := range ids {
+						hostIDs[id] = append(hostIDs[id], contractID)
+					}
+					continue
+				}
+
+				deleted, err := isContractDeleted(sub, contractID)
+				if err != nil {
+					log.Printf("couldn't check contract %d: %s", contractID, err)
+					continue
+				}
+				if !deleted {
+					for _, id := range ids {
+						hostIDs[id] = append(hostIDs[id], contractID)
+					}
+					continue
+				}
+
+				log.Printf("network %s: pruning host ids %v of deleted contract %d on node %d", name, ids, contractID, nodeID)
+				network.DeleteDeploymentHostIDs(nodeID, contractID)
+			}
+
+			for id, contractIDs := range hostIDs {
+				if len(contractIDs) < 2 {
+					continue
+				}
+				sort.Slice(contractIDs, func(i, j int) bool { return contractIDs[i] < contractIDs[j] })
+				conflicts = append(conflicts, fmt.Sprintf("network %s: host id %d on node %d is used by contracts %v", name, id, nodeID, contractIDs))
+			}
+		}
+	}
+
+	if len(conflicts) != 0 {
+		sort.Strings(conflicts)
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "conflicting network state allocations",
+			Detail:   strings.Join(conflicts, "\n"),
+		})
+	}
+	return diags
+}
+
+func isContractDeleted(sub subi.SubstrateExt, contractID uint64) (bool, error) {
+	contract, err := sub.GetContract(contractID)
+	if errors.Is(err, substrate.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return contract.IsDeleted(), nil
+}
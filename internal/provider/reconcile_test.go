@@ -0,0 +1,87 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+)
+
+// fakeContracts is a substrate connection only knowing the states of some contracts
+type fakeContracts struct {
+	subi.SubstrateExt
+	states map[uint64]substrate.ContractState
+	// failing contracts can't be fetched
+	failing map[uint64]bool
+}
+
+func (f fakeContracts) GetContract(contractID uint64) (subi.Contract, error) {
+	if f.failing[contractID] {
+		return subi.Contract{}, fmt.Errorf("connection lost")
+	}
+	contractState, ok := f.states[contractID]
+	if !ok {
+		return subi.Contract{}, substrate.ErrNotFound
+	}
+	return subi.Contract{Contract: &substrate.Contract{State: contractState}}, nil
+}
+
+func TestReconcileNetworks(t *testing.T) {
+	networks := state.NetworkState{}
+	network := networks.GetNetwork("net1")
+	// node 1: live contract 10, contract 11 deleted on chain, contract 12 in grace period but missing from graphql,
+	// contract 13 unknown to the chain, contract 14 couldn't be checked
+	network.SetDeploymentHostIDs(1, 10, []byte{2})
+	network.SetDeploymentHostIDs(1, 11, []byte{3})
+	network.SetDeploymentHostIDs(1, 12, []byte{4})
+	network.SetDeploymentHostIDs(1, 13, []byte{5})
+	network.SetDeploymentHostIDs(1, 14, []byte{6})
+	// node 1: a running operation reserved host id 7, a crashed one left its reservation of host id 8 behind
+	reserved := newReservationKey()
+	stale := reservationBit | uint64(time.Now().Add(-2*staleReservationAge).Unix())<<reservationTimeShift
+	network.SetDeploymentHostIDs(1, reserved, []byte{7})
+	network.SetDeploymentHostIDs(1, stale, []byte{8})
+	// node 2: live contracts 20 and 21 sharing host id 2, contract 22 recorded on node 2 but deployed on node 3
+	network.SetDeploymentHostIDs(2, 20, []byte{2})
+	network.SetDeploymentHostIDs(2, 21, []byte{2, 3})
+	network.SetDeploymentHostIDs(2, 22, []byte{4})
+
+	live := map[uint64]uint32{10: 1, 20: 2, 21: 2, 22: 3}
+	sub := fakeContracts{
+		states: map[uint64]substrate.ContractState{
+			11: {IsDeleted: true},
+			12: {IsGracePeriod: true},
+		},
+		failing: map[uint64]bool{14: true},
+	}
+
+	diags := reconcileNetworks(networks, live, sub, time.Now())
+
+	expected := map[uint32]map[uint64][]byte{
+		1: {10: {2}, 12: {4}, 14: {6}, reserved: {7}},
+		2: {20: {2}, 21: {2, 3}, 22: {4}},
+	}
+	for nodeID, contracts := range expected {
+		if !reflect.DeepEqual(map[uint64][]byte(network.NodeDeploymentHostIDs[nodeID]), contracts) {
+			t.Fatalf("node %d: expected host ids %v, got %v", nodeID, contracts, network.NodeDeploymentHostIDs[nodeID])
+		}
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("expected one conflicts warning, got %v", diags)
+	}
+	for _, conflict := range []string{
+		"host id 2 on node 2 is used by contracts [20 21]",
+		"contract 22 is recorded on node 2 but is deployed on node 3",
+	} {
+		if !strings.Contains(diags[0].Detail, conflict) {
+			t.Fatalf("expected conflict %q in %q", conflict, diags[0].Detail)
+		}
+	}
+}
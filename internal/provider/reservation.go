@@ -0,0 +1,155 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+const (
+	// reservationBit marks the keys of the host ids reserved for deployments whose contracts aren't created yet,
+	// contract ids never get that high
+	reservationBit = uint64(1) << 63
+	// reservationTimeShift is the position of the reservation time in a reservation key, the bits below it are random
+	reservationTimeShift = 20
+	// staleReservationAge is the age of a reservation left behind by a crashed run
+	staleReservationAge = 24 * time.Hour
+)
+
+// hostIDsReserver assigns the private ips of a resource about to be deployed, and reserves their host ids in the
+// networks under key. It's run under the state lock on a fresh copy of the state, so concurrent runs sharing the
+// state can't pick the same host ids.
+type hostIDsReserver func(d *schema.ResourceData, networks state.NetworkState, key uint64) error
+
+// hostIDsReservers are the reservers of the resources deploying vms on a network
+var hostIDsReservers = map[string]hostIDsReserver{
+	"grid_deployment": reserveDeploymentHostIDs,
+	"grid_kubernetes": reserveK8sHostIDs,
+}
+
+// newReservationKey returns the key the host ids of an operation are reserved under until it's done
+func newReservationKey() uint64 {
+	return reservationBit | uint64(time.Now().Unix())<<reservationTimeShift | uint64(rand.Intn(1<<reservationTimeShift))
+}
+
+// isReservation tells if a key of the network state is a reservation rather than a contract id
+func isReservation(key uint64) bool {
+	return key&reservationBit != 0
+}
+
+// isStaleReservation tells if a reservation was left behind by a crashed run
+func isStaleReservation(key uint64, now time.Time) bool {
+	reserved := time.Unix(int64(key&^reservationBit>>reservationTimeShift), 0)
+	return now.Sub(reserved) > staleReservationAge
+}
+
+// releaseHostIDs removes the host ids reserved under key, the deployed contracts record their own host ids
+func releaseHostIDs(networks state.NetworkState, key uint64) {
+	for _, network := range networks {
+		for nodeID := range network.NodeDeploymentHostIDs {
+			network.DeleteDeploymentHostIDs(nodeID, key)
+		}
+	}
+}
+
+// reservedNetwork returns a network of the state ready to hold reservations, false if the state doesn't have it
+func reservedNetwork(networks state.NetworkState, name string) (state.Network, bool) {
+	network, ok := networks[name]
+	if ok && network.NodeDeploymentHostIDs == nil {
+		network.NodeDeploymentHostIDs = state.NodeDeploymentHostIDs{}
+		networks[name] = network
+	}
+	return network, ok
+}
+
+func reserveDeploymentHostIDs(d *schema.ResourceData, networks state.NetworkState, key uint64) error {
+	network, ok := reservedNetwork(networks, d.Get("network_name").(string))
+	vms := d.Get("vms").([]interface{})
+	if !ok || len(vms) == 0 {
+		return nil
+	}
+
+	nodeID := uint32(d.Get("node").(int))
+	if err := reserveNodeHostIDs(network, nodeID, key, vms); err != nil {
+		return errors.Wrapf(err, "couldn't assign the vms ips on node %d", nodeID)
+	}
+	return d.Set("vms", vms)
+}
+
+func reserveK8sHostIDs(d *schema.ResourceData, networks state.NetworkState, key uint64) error {
+	network, ok := reservedNetwork(networks, d.Get("network_name").(string))
+	if !ok {
+		return nil
+	}
+
+	master := d.Get("master").([]interface{})
+	workers := d.Get("workers").([]interface{})
+	nodes := map[uint32][]interface{}{}
+	for _, k8sNode := range append(append([]interface{}{}, master...), workers...) {
+		nodeID := uint32(k8sNode.(map[string]interface{})["node"].(int))
+		nodes[nodeID] = append(nodes[nodeID], k8sNode)
+	}
+	for nodeID, k8sNodes := range nodes {
+		if err := reserveNodeHostIDs(network, nodeID, key, k8sNodes); err != nil {
+			return errors.Wrapf(err, "couldn't assign the kubernetes nodes ips on node %d", nodeID)
+		}
+	}
+
+	if err := d.Set("master", master); err != nil {
+		return err
+	}
+	return d.Set("workers", workers)
+}
+
+// reserveNodeHostIDs assigns the free host ids of the node to the machines without an ip in the node's subnet,
+// the same way the deployers do, and reserves the host ids of all the machines under key.
+// The machines are the maps of the resource data, holding their ip in the "ip" field.
+func reserveNodeHostIDs(network state.Network, nodeID uint32, key uint64, machines []interface{}) error {
+	subnet := network.GetNodeSubnet(nodeID)
+	if subnet == "" {
+		// the network isn't deployed on the node, the deployer reports it
+		return nil
+	}
+	_, ipRange, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return errors.Wrapf(err, "invalid subnet %s", subnet)
+	}
+
+	used := network.GetUsedNetworkHostIDs(nodeID)
+	reserved := []byte{}
+	for _, machine := range machines {
+		ip := net.ParseIP(machine.(map[string]interface{})["ip"].(string)).To4()
+		if ip != nil && ipRange.Contains(ip) {
+			reserved = append(reserved, ip[3])
+		}
+	}
+
+	hostID := byte(2)
+	for _, machine := range machines {
+		m := machine.(map[string]interface{})
+		ip := net.ParseIP(m["ip"].(string)).To4()
+		if ip != nil && ipRange.Contains(ip) {
+			continue
+		}
+
+		for workloads.Contains(used, hostID) || workloads.Contains(reserved, hostID) {
+			if hostID == 254 {
+				return errors.New("all 253 ips of the network are exhausted")
+			}
+			hostID++
+		}
+		ip = append(net.IP{}, ipRange.IP.To4()...)
+		ip[3] = hostID
+		m["ip"] = ip.String()
+		reserved = append(reserved, hostID)
+	}
+
+	network.SetDeploymentHostIDs(nodeID, key, reserved)
+	return nil
+}
@@ -0,0 +1,49 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+)
+
+func TestReserveNodeHostIDs(t *testing.T) {
+	networks := state.NetworkState{}
+	network := networks.GetNetwork("net1")
+	network.SetNodeSubnet(1, "10.1.2.0/24")
+	network.SetDeploymentHostIDs(1, 10, []byte{2})
+
+	first, second := newReservationKey(), newReservationKey()+1
+	machines := []interface{}{
+		map[string]interface{}{"ip": ""},
+		map[string]interface{}{"ip": "10.1.2.9"},
+		// an ip out of the node's subnet is reassigned
+		map[string]interface{}{"ip": "10.1.3.5"},
+	}
+	if err := reserveNodeHostIDs(network, 1, first, machines); err != nil {
+		t.Fatal(err)
+	}
+	ips := []string{}
+	for _, m := range machines {
+		ips = append(ips, m.(map[string]interface{})["ip"].(string))
+	}
+	if expected := []string{"10.1.2.3", "10.1.2.9", "10.1.2.4"}; !reflect.DeepEqual(ips, expected) {
+		t.Fatalf("expected ips %v, got %v", expected, ips)
+	}
+
+	// a concurrent operation sees the reservation
+	machine := map[string]interface{}{"ip": ""}
+	if err := reserveNodeHostIDs(network, 1, second, []interface{}{machine}); err != nil {
+		t.Fatal(err)
+	}
+	if machine["ip"] != "10.1.2.5" {
+		t.Fatalf("expected the next free ip 10.1.2.5, got %s", machine["ip"])
+	}
+
+	releaseHostIDs(networks, first)
+	expected := state.DeploymentHostIDs{10: {2}, second: {5}}
+	if !reflect.DeepEqual(network.NodeDeploymentHostIDs[1], expected) {
+		t.Fatalf("expected host ids %v after the release, got %v", expected, network.NodeDeploymentHostIDs[1])
+	}
+}
@@ -9,7 +9,9 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 func resourceDeployment() *schema.Resource {
@@ -20,9 +22,13 @@ func resourceDeployment() *schema.Resource {
 		ReadContext:   resourceDeploymentRead,
 		UpdateContext: resourceDeploymentUpdate,
 		DeleteContext: resourceDeploymentDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceDeploymentImport,
+		},
 
 		Timeouts: &schema.ResourceTimeout{
 			Create: schema.DefaultTimeout(45 * time.Minute),
+			Update: schema.DefaultTimeout(45 * time.Minute),
 		},
 
 		Schema: map[string]*schema.Schema{
@@ -31,6 +37,17 @@ func resourceDeployment() *schema.Resource {
 				Required:    true,
 				Description: "Node id to place the deployment on.",
 			},
+			"migrate_on_node_change": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Migrate the deployment when its node changes instead of dropping its data: the deployment is created on the new node first, `migration_hook` copies the data, and the old contract is only canceled once both succeeded, otherwise the new contract is canceled and the deployment stays on the old node. The network has to include both nodes during the migration.",
+			},
+			"migration_hook": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Shell command run on the machine running terraform to copy the data of disks and zdbs when migrating the deployment, e.g. with rsync or redis clients over the wireguard network. It gets the environment variables `GRID_MIGRATION_OLD_NODE`, `GRID_MIGRATION_NEW_NODE`, `GRID_MIGRATION_OLD_CONTRACT`, `GRID_MIGRATION_NEW_CONTRACT`, and `GRID_MIGRATION_OLD_WORKLOADS` and `GRID_MIGRATION_NEW_WORKLOADS` holding the vms, disks, and zdbs as json, in the same format as this resource. A non zero exit code rolls the migration back.",
+			},
 			"name": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -497,6 +514,10 @@ func resourceDeploymentUpdate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
+	if d.HasChange("node") && d.Get("migrate_on_node_change").(bool) {
+		return migrateDeployment(ctx, d, tfPluginClient)
+	}
+
 	if d.HasChange("node") {
 		oldContractID, err := strconv.ParseUint(d.Id(), 10, 64)
 		if err != nil {
@@ -555,3 +576,47 @@ func resourceDeploymentDelete(ctx context.Context, d *schema.ResourceData, meta
 
 	return diags
 }
+
+// resourceDeploymentImport adopts an existing node contract: the deployment is fetched from its node and the
+// resource state is rebuilt from its workloads.
+func resourceDeploymentImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse contract id %s", d.Id())
+	}
+
+	contract, zosDeployment, err := loadNodeContractDeployment(ctx, tfPluginClient, contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeID := uint32(contract.ContractType.NodeContract.Node)
+	dl, err := workloads.NewDeploymentFromZosDeployment(zosDeployment, nodeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load deployment %d", contractID)
+	}
+	if ok, solutionProvider := contract.SolutionProviderID.Unwrap(); ok {
+		solutionProviderID := uint64(solutionProvider)
+		dl.SolutionProvider = &solutionProviderID
+	}
+
+	// sync also reserves the vms host ids in the local network state
+	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, &dl); err != nil {
+		return nil, errors.Wrap(err, "couldn't sync deployment")
+	}
+
+	if err := d.Set("name", dl.Name); err != nil {
+		return nil, errors.Wrap(err, "couldn't set deployment name")
+	}
+
+	if err := syncContractsDeployments(d, &dl); err != nil {
+		return nil, errors.Wrap(err, "couldn't set deployment data to the resource")
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
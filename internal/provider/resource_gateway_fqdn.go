@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 func resourceGatewayFQDNProxy() *schema.Resource {
@@ -19,6 +22,9 @@ func resourceGatewayFQDNProxy() *schema.Resource {
 		ReadContext:   resourceGatewayFQDNRead,
 		UpdateContext: resourceGatewayFQDNUpdate,
 		DeleteContext: resourceGatewayFQDNDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGatewayFQDNImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -187,3 +193,57 @@ func resourceGatewayFQDNDelete(ctx context.Context, d *schema.ResourceData, meta
 
 	return diags
 }
+
+// resourceGatewayFQDNImport adopts an existing fqdn gateway from its node contract.
+func resourceGatewayFQDNImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	id, err := parseImportID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	nodeID, dl, wl, err := loadGatewayWorkload(ctx, tfPluginClient, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentData, err := workloads.ParseDeploymentData(dl.Metadata)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse metadata of contract %d", dl.ContractID)
+	}
+
+	gw := workloads.GatewayFQDNProxy{
+		NodeID:           nodeID,
+		Name:             id.name,
+		Description:      wl.Description,
+		SolutionType:     deploymentData.ProjectName,
+		NodeDeploymentID: map[uint32]uint64{nodeID: dl.ContractID},
+		ContractID:       dl.ContractID,
+	}
+	if err := tfPluginClient.GatewayFQDNDeployer.Sync(ctx, &gw); err != nil {
+		return nil, errors.Wrap(err, "couldn't sync fqdn gateway")
+	}
+
+	var errs error
+	if err := d.Set("name", gw.Name); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("description", gw.Description); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("solution_type", gw.SolutionType); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := syncContractsFQDNGateways(d, &gw); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return nil, errors.Wrap(errs, "couldn't set fqdn gateway data to the resource")
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
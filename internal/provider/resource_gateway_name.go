@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 func resourceGatewayNameProxy() *schema.Resource {
@@ -18,6 +21,9 @@ func resourceGatewayNameProxy() *schema.Resource {
 		ReadContext:   resourceGatewayNameRead,
 		UpdateContext: resourceGatewayNameUpdate,
 		DeleteContext: resourceGatewayNameDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGatewayNameImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -189,3 +195,63 @@ func resourceGatewayNameDelete(ctx context.Context, d *schema.ResourceData, meta
 
 	return diags
 }
+
+// resourceGatewayNameImport adopts an existing name gateway from its node contract, the name contract is looked up by the gateway name.
+func resourceGatewayNameImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	id, err := parseImportID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	nodeID, dl, wl, err := loadGatewayWorkload(ctx, tfPluginClient, id)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentData, err := workloads.ParseDeploymentData(dl.Metadata)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse metadata of contract %d", dl.ContractID)
+	}
+
+	nameContractID, err := tfPluginClient.SubstrateConn.GetContractIDByNameRegistration(id.name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get name contract of %s", id.name)
+	}
+
+	gw := workloads.GatewayNameProxy{
+		NodeID:           nodeID,
+		Name:             id.name,
+		Description:      wl.Description,
+		SolutionType:     deploymentData.ProjectName,
+		NodeDeploymentID: map[uint32]uint64{nodeID: dl.ContractID},
+		NameContractID:   nameContractID,
+		ContractID:       dl.ContractID,
+	}
+	if err := tfPluginClient.GatewayNameDeployer.Sync(ctx, &gw); err != nil {
+		return nil, errors.Wrap(err, "couldn't sync name gateway")
+	}
+
+	var errs error
+	if err := d.Set("name", gw.Name); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("description", gw.Description); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("solution_type", gw.SolutionType); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := syncContractsNameGateways(d, &gw); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return nil, errors.Wrap(errs, "couldn't set name gateway data to the resource")
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
@@ -4,12 +4,17 @@ package provider
 import (
 	"context"
 	"fmt"
+	"net"
 
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 )
 
 func resourceKubernetes() *schema.Resource {
@@ -21,6 +26,9 @@ func resourceKubernetes() *schema.Resource {
 		ReadContext:   resourceK8sRead,
 		UpdateContext: resourceK8sUpdate,
 		DeleteContext: resourceK8sDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceK8sImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -352,3 +360,116 @@ func resourceK8sDelete(ctx context.Context, d *schema.ResourceData, meta interfa
 	}
 	return diags
 }
+
+// resourceK8sImport adopts an existing cluster from the node contracts listed in the import id.
+// The master is the only vm that doesn't join the cluster through K3S_URL.
+func resourceK8sImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	id, err := parseImportID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	deployments, err := loadNodesDeployments(ctx, tfPluginClient, id.contracts)
+	if err != nil {
+		return nil, err
+	}
+
+	k8sCluster := workloads.K8sCluster{
+		NodeDeploymentID: make(map[uint32]uint64),
+		NodesIPRange:     make(map[uint32]gridtypes.IPNet),
+	}
+	for nodeID, dl := range deployments {
+		k8sCluster.NodeDeploymentID[nodeID] = dl.ContractID
+	}
+	k8sCluster.Master, k8sCluster.SolutionType, err = findK8sMaster(deployments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't find the master node in the contracts %v", id.contracts)
+	}
+
+	if err := tfPluginClient.K8sDeployer.UpdateFromRemote(ctx, &k8sCluster); err != nil {
+		return nil, errors.Wrap(err, "couldn't update k8s cluster from remote")
+	}
+
+	// nodes subnets are taken from the local network state, falling back to the vms ips if the network isn't known
+	network := tfPluginClient.State.Networks.GetNetwork(k8sCluster.NetworkName)
+	for _, node := range append([]workloads.K8sNode{*k8sCluster.Master}, k8sCluster.Workers...) {
+		if _, ok := k8sCluster.NodesIPRange[node.Node]; ok {
+			continue
+		}
+		subnet := network.GetNodeSubnet(node.Node)
+		if subnet == "" {
+			subnet, err = vmSubnet(node.IP)
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldn't get the subnet of vm %s", node.Name)
+			}
+			network.SetNodeSubnet(node.Node, subnet)
+		}
+		k8sCluster.NodesIPRange[node.Node], err = gridtypes.ParseIPNet(subnet)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse node %d ip range", node.Node)
+		}
+	}
+
+	var errs error
+	if err := d.Set("name", id.name); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := storeK8sState(d, &k8sCluster, *tfPluginClient.State); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return nil, errors.Wrap(errs, "couldn't set k8s cluster data to the resource")
+	}
+
+	d.SetId(uuid.New().String())
+	return []*schema.ResourceData{d}, nil
+}
+
+// findK8sMaster finds the master vm of a cluster, the only one that doesn't join the cluster through K3S_URL.
+// It also returns the solution type of the cluster, taken from the master's deployment.
+func findK8sMaster(deployments map[uint32]gridtypes.Deployment) (*workloads.K8sNode, string, error) {
+	var master *workloads.K8sNode
+	var solutionType string
+	for _, dl := range deployments {
+		for _, wl := range dl.Workloads {
+			if wl.Type != zos.ZMachineType {
+				continue
+			}
+			data, err := wl.WorkloadData()
+			if err != nil {
+				return nil, "", errors.Wrapf(err, "couldn't load vm %s data", wl.Name)
+			}
+			if _, ok := data.(*zos.ZMachine).Env["K3S_URL"]; ok {
+				continue
+			}
+			if master != nil {
+				return nil, "", fmt.Errorf("found multiple masters: %s and %s", master.Name, wl.Name)
+			}
+
+			deploymentData, err := workloads.ParseDeploymentData(dl.Metadata)
+			if err != nil {
+				return nil, "", errors.Wrapf(err, "couldn't parse metadata of contract %d", dl.ContractID)
+			}
+			master = &workloads.K8sNode{Name: wl.Name.String()}
+			solutionType = deploymentData.ProjectName
+		}
+	}
+	if master == nil {
+		return nil, "", errors.New("no vm without K3S_URL")
+	}
+	return master, solutionType, nil
+}
+
+// vmSubnet returns the /24 wireguard subnet of a vm ip, as the network deployer assigns one to each node
+func vmSubnet(ip string) (string, error) {
+	vmIP := net.ParseIP(ip).To4()
+	if vmIP == nil {
+		return "", fmt.Errorf("couldn't parse ip '%s'", ip)
+	}
+	return workloads.NewIPRange(net.IPNet{IP: vmIP.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String(), nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 
 	"github.com/google/uuid"
@@ -15,6 +16,7 @@ import (
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -27,6 +29,9 @@ func resourceNetwork() *schema.Resource {
 		ReadContext:   resourceNetworkRead,
 		UpdateContext: resourceNetworkUpdate,
 		DeleteContext: resourceNetworkDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceNetworkImport,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -389,3 +394,121 @@ func resourceNetworkDelete(ctx context.Context, d *schema.ResourceData, meta int
 	}
 	return diags
 }
+
+// resourceNetworkImport adopts an existing network from the node contracts listed in the import id.
+// The wireguard user access can't be recovered since its private key is never sent to the nodes, so importing a network
+// with a peer outside of its nodes fails.
+func resourceNetworkImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	id, err := parseImportID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	deployments, err := loadNodesDeployments(ctx, tfPluginClient, id.contracts)
+	if err != nil {
+		return nil, err
+	}
+
+	externalSK, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate external_sk key")
+	}
+
+	net := workloads.ZNet{
+		Name:             id.name,
+		ExternalSK:       externalSK,
+		NodesIPRange:     make(map[uint32]gridtypes.IPNet),
+		NodeDeploymentID: make(map[uint32]uint64),
+		Keys:             make(map[uint32]wgtypes.Key),
+		WGPort:           make(map[uint32]int),
+	}
+	var peers []zos.Peer
+	for nodeID, dl := range deployments {
+		wl, err := dl.Get(gridtypes.Name(id.name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't find network %s in contract %d", id.name, dl.ContractID)
+		}
+
+		nodeNet, err := workloads.NewNetworkFromWorkload(*wl.Workload, nodeID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load network from contract %d", dl.ContractID)
+		}
+
+		deploymentData, err := workloads.ParseDeploymentData(dl.Metadata)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse metadata of contract %d", dl.ContractID)
+		}
+
+		net.Description = nodeNet.Description
+		net.IPRange = nodeNet.IPRange
+		net.SolutionType = deploymentData.ProjectName
+		net.Nodes = append(net.Nodes, nodeID)
+		net.NodesIPRange[nodeID] = nodeNet.NodesIPRange[nodeID]
+		net.NodeDeploymentID[nodeID] = dl.ContractID
+		for node, key := range nodeNet.Keys {
+			net.Keys[node] = key
+		}
+		for node, port := range nodeNet.WGPort {
+			net.WGPort[node] = port
+		}
+
+		data, err := wl.WorkloadData()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load network data from contract %d", dl.ContractID)
+		}
+		peers = append(peers, data.(*zos.Network).Peers...)
+	}
+
+	// the key of the wireguard user access only exists in the state that created it, importing the network
+	// without it would drop the access on the next apply
+	if peer := externalNetworkPeer(net.NodesIPRange, peers); peer != nil {
+		return nil, fmt.Errorf(
+			"network %s has a peer with subnet %s outside of the imported nodes: either a node contract is missing from the import id or the network has wireguard access (add_wg_access), which can't be imported",
+			id.name, peer.Subnet,
+		)
+	}
+
+	sort.Slice(net.Nodes, func(i, j int) bool { return net.Nodes[i] < net.Nodes[j] })
+
+	var errs error
+	if err := d.Set("name", net.Name); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("description", net.Description); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("solution_type", net.SolutionType); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("add_wg_access", false); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := storeState(d, tfPluginClient, &net); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil {
+		return nil, errors.Wrap(errs, "couldn't set network data to the resource")
+	}
+
+	d.SetId(uuid.New().String())
+	return []*schema.ResourceData{d}, nil
+}
+
+// externalNetworkPeer returns the first peer that isn't one of the network nodes, like the wireguard user access
+func externalNetworkPeer(nodesIPRange map[uint32]gridtypes.IPNet, peers []zos.Peer) *zos.Peer {
+	for i, peer := range peers {
+		found := false
+		for _, ipRange := range nodesIPRange {
+			found = found || ipRange.String() == peer.Subnet.String()
+		}
+		if !found {
+			return &peers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func resourceRentContract() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description:   "Resource for renting a node. The node becomes dedicated to the provider's twin, which gets a discount on the deployments on it. Changing the node cancels the rent contract and creates a new one.",
+		CreateContext: resourceRentContractCreate,
+		ReadContext:   resourceRentContractRead,
+		DeleteContext: resourceRentContractDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceRentContractImport,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"node": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Node id to rent, the node must be rentable (e.g. a `dedicated` node from `grid_scheduler`).",
+			},
+			"solution_provider": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Solution provider id of the rent contract.",
+			},
+			"contract_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The id of the created rent contract.",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Rent contract state, one of: Created GracePeriod Deleted.",
+			},
+			"amount_billed": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Total amount billed for the rent contract, in TFT units (1 TFT = 10^7 units).",
+			},
+			"last_billed_at": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Unix timestamp of the last bill of the rent contract, 0 if it wasn't billed yet.",
+			},
+		},
+	}
+}
+
+// rentContractsClient returns the substrate client able to create rent contracts,
+// the grid client substrate interface only covers node and name contracts.
+func rentContractsClient(tfPluginClient *deployer.TFPluginClient) (*subi.SubstrateImpl, error) {
+	sub, ok := tfPluginClient.SubstrateConn.(*subi.SubstrateImpl)
+	if !ok {
+		return nil, fmt.Errorf("substrate connection %T doesn't support rent contracts", tfPluginClient.SubstrateConn)
+	}
+	return sub, nil
+}
+
+func contractState(state substrate.ContractState) string {
+	switch {
+	case state.IsCreated:
+		return "Created"
+	case state.IsGracePeriod:
+		return "GracePeriod"
+	default:
+		return "Deleted"
+	}
+}
+
+func resourceRentContractCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	sub, err := rentContractsClient(tfPluginClient)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	nodeID := uint32(d.Get("node").(int))
+	existing, err := sub.GetNodeRentContract(nodeID)
+	if err == nil {
+		return diag.Errorf("node %d is already rented with contract %d, import it instead", nodeID, existing)
+	}
+	if !errors.Is(err, substrate.ErrNotFound) {
+		return diag.FromErr(errors.Wrapf(err, "couldn't get the rent contract of node %d", nodeID))
+	}
+
+	var solutionProvider *uint64
+	if v, ok := d.GetOk("solution_provider"); ok {
+		id := uint64(v.(int))
+		solutionProvider = &id
+	}
+	contractID, err := sub.CreateRentContract(tfPluginClient.Identity, nodeID, solutionProvider)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't rent node %d", nodeID))
+	}
+	d.SetId(strconv.FormatUint(contractID, 10))
+
+	return resourceRentContractRead(ctx, d, meta)
+}
+
+func resourceRentContractRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't parse rent contract id '%s'", d.Id()))
+	}
+
+	contract, err := tfPluginClient.SubstrateConn.GetContract(contractID)
+	if errors.Is(err, substrate.ErrNotFound) || (err == nil && contract.IsDeleted()) {
+		// the contract was canceled outside terraform, it has to be created again
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't get rent contract %d", contractID))
+	}
+	if !contract.ContractType.IsRentContract {
+		return diag.Errorf("contract %d is not a rent contract", contractID)
+	}
+	if contract.TwinID() != tfPluginClient.TwinID {
+		return diag.Errorf("contract %d is not owned by twin %d", contractID, tfPluginClient.TwinID)
+	}
+
+	var errs error
+	if err := d.Set("node", int(contract.ContractType.RentContract.Node)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("contract_id", int(contractID)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := d.Set("state", contractState(contract.State)); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if ok, id := contract.SolutionProviderID.Unwrap(); ok {
+		if err := d.Set("solution_provider", int(id)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	amountBilled, lastBilledAt, err := rentContractBilling(tfPluginClient, contractID)
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("couldn't read the billing of rent contract %d", contractID),
+			Detail:   err.Error(),
+		})
+	} else {
+		if err := d.Set("amount_billed", int(amountBilled)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if err := d.Set("last_billed_at", int(lastBilledAt)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return append(diags, diag.FromErr(errors.Wrap(errs, "couldn't set rent contract"))...)
+	}
+	return diags
+}
+
+// rentContractBilling sums the bills of a contract from the grid proxy and returns the timestamp of the last one
+func rentContractBilling(tfPluginClient *deployer.TFPluginClient, contractID uint64) (amountBilled uint64, lastBilledAt uint64, err error) {
+	contracts, err := listContracts(tfPluginClient.GridProxyClient, proxyTypes.ContractFilter{
+		ContractID: &contractID,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, contract := range contracts {
+		for _, billing := range contract.Billing {
+			amountBilled += billing.AmountBilled
+			if billing.Timestamp > lastBilledAt {
+				lastBilledAt = billing.Timestamp
+			}
+		}
+	}
+	return amountBilled, lastBilledAt, nil
+}
+
+func resourceRentContractDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't parse rent contract id '%s'", d.Id()))
+	}
+
+	if err := tfPluginClient.SubstrateConn.CancelContract(tfPluginClient.Identity, contractID); err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't cancel rent contract %d", contractID))
+	}
+	d.SetId("")
+	return nil
+}
+
+// resourceRentContractImport adopts an existing rent contract of the current twin from its contract id, terraform reads it after.
+func resourceRentContractImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid import id '%s', expected the rent contract id", d.Id())
+	}
+	contract, err := tfPluginClient.SubstrateConn.GetContract(contractID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get contract %d", contractID)
+	}
+	if !contract.ContractType.IsRentContract {
+		return nil, fmt.Errorf("contract %d is not a rent contract", contractID)
+	}
+	if contract.IsDeleted() {
+		return nil, fmt.Errorf("contract %d is deleted", contractID)
+	}
+	if contract.TwinID() != tfPluginClient.TwinID {
+		return nil, fmt.Errorf("contract %d is not owned by twin %d", contractID, tfPluginClient.TwinID)
+	}
+	return []*schema.ResourceData{d}, nil
+}
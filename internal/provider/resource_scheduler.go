@@ -4,14 +4,19 @@ package provider
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/terraform-provider-grid/internal/provider/scheduler"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 )
 
@@ -22,6 +27,7 @@ func resourceScheduler() *schema.Resource {
 		UpdateContext: ResourceSchedUpdate,
 		ReadContext:   ResourceSchedRead,
 		DeleteContext: ResourceSchedDelete,
+		CustomizeDiff: planReschedule,
 		Schema: map[string]*schema.Schema{
 			"requests": {
 				Type:        schema.TypeList,
@@ -72,7 +78,12 @@ func resourceScheduler() *schema.Resource {
 						"certified": {
 							Type:        schema.TypeBool,
 							Optional:    true,
-							Description: "Flag to pick only certified nodes (Not implemented).",
+							Description: "Flag to pick only certified nodes.",
+						},
+						"farm_certification": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Certification type of the node's farm, one of: NotCertified Gold.",
 						},
 						"dedicated": {
 							Type:        schema.TypeBool,
@@ -93,60 +104,333 @@ func resourceScheduler() *schema.Resource {
 							Default:     false,
 							Description: "True to ensure this request returns a distinct node relative to this scheduler resource.",
 						},
+						"strategy": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Strategy ranking the eligible nodes, one of: random bin_packing spread cheapest uptime. Defaults to random.",
+						},
+						"placement_group": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Name of the placement group constraining this request relative to the other requests of the group.",
+						},
+						"same_farm_as": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Name of the request this request must share the farm with.",
+						},
+						"country": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Country of the node.",
+						},
+						"city": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "City of the node.",
+						},
+						"ipv4": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Flag to pick only nodes with public ipv4 configuration.",
+						},
+						"ipv6": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Flag to pick only nodes with public ipv6 configuration.",
+						},
+						"min_zos_version": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Minimum zos version of the node, e.g. 3.6.0. The version is read from the candidate nodes over RMB.",
+						},
+						"rented_by_me": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Flag to pick only nodes rented by the provider's twin.",
+						},
+						"prefer_rented_by_me": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Flag to pick the nodes rented by the provider's twin first, before applying the strategy to the other nodes.",
+						},
+						"farm_exclude": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+							Description: "List of farm ids you want to exclude from the search.",
+						},
+						"max_monthly_price": {
+							Type:        schema.TypeFloat,
+							Optional:    true,
+							Description: "Maximum estimated monthly cost of the request in USD, 0 for no limit. The cost is estimated from the requested capacity, public ips and the node certification with the pricing policy of the node's farm, only the public ips are paid on the nodes rented by the provider's twin, with the discount of the dedicated nodes.",
+						},
+					},
+				},
+			},
+			"placement_groups": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "List of placement groups. The scheduler assigns all the requests together so that every group's constraints are satisfied.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Placement group name. Used as a reference in the requests' `placement_group`.",
+						},
+						"distinct_farms": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "True to place every request of the group on a different farm.",
+						},
+						"distinct_countries": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "True to place every request of the group in a different country.",
+						},
+						"distinct_cities": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "True to place every request of the group in a different city.",
+						},
+						"max_per_node": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum count of the group's requests on a node, 0 for no limit.",
+						},
 					},
 				},
 			},
+			"reschedule_on_failure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "True to reassign on the next apply the requests whose nodes went down, got rented by someone else, lost capacity or left the grid. The healthy assignments are kept.",
+			},
+			"farmerbot_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     int(scheduler.DefaultFarmerBotConfig.Timeout),
+				Description: "Timeout in seconds of the jobs sent to the farmer bots, the bots are waited for up to the provider's `rmb_timeout`.",
+			},
+			"farmerbot_grace_period": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     int(scheduler.DefaultFarmerBotConfig.GracePeriod),
+				Description: "Grace period in seconds of the jobs sent to the farmer bots.",
+			},
+			"farmerbot_power_on_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     int(scheduler.DefaultFarmerBotConfig.PowerOnTimeout / time.Second),
+				Description: "Seconds to wait for a node powered on by a farmer bot to be up.",
+			},
+			"seed": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Non zero seed of the random choices, so rerunning the scheduler on the same grid gives the same plan.",
+			},
 			"nodes": {
 				Type:        schema.TypeMap,
 				Computed:    true,
 				Elem:        &schema.Schema{Type: schema.TypeInt},
 				Description: "Mapping from the request name to the node id.",
 			},
+			"explanations": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Mapping from the request name to how its node was searched: the count of considered nodes, the request fields rejecting them and the picked node.",
+			},
+			"costs": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeFloat},
+				Description: "Mapping from the request name to the estimated monthly cost in USD of its assignment.",
+			},
 		},
 	}
 }
 
 func parseAssignment(d *schema.ResourceData) map[string]uint32 {
-	assignmentIfs := d.Get("nodes").(map[string]interface{})
+	// the planned nodes are unknown when requests are rescheduled, the assignment to keep is the previous one
+	assignmentIfs, _ := d.GetChange("nodes")
 	assignment := make(map[string]uint32)
-	for k, v := range assignmentIfs {
+	for k, v := range assignmentIfs.(map[string]interface{}) {
 		assignment[k] = uint32(v.(int))
 	}
 	return assignment
 }
 
-func parseRequests(d *schema.ResourceData, assignment map[string]uint32) []scheduler.Request {
+// parseRequests reads all the requests, the scheduler skips the ones already assigned
+func parseRequests(d *schema.ResourceData) ([]scheduler.Request, error) {
 	reqsIfs := d.Get("requests").([]interface{})
 	reqs := make([]scheduler.Request, 0)
 	for _, r := range reqsIfs {
 		mp := r.(map[string]interface{})
-		if _, ok := assignment[mp["name"].(string)]; ok {
-			// skip already assigned ones
-			continue
-		}
 		nodesToExcludeIF := mp["node_exclude"].([]interface{})
 		nodesToExclude := make([]uint32, len(nodesToExcludeIF))
 		for idx, n := range nodesToExcludeIF {
 			nodesToExclude[idx] = uint32(n.(int))
 		}
 
+		farmsToExcludeIF := mp["farm_exclude"].([]interface{})
+		farmsToExclude := make([]uint32, len(farmsToExcludeIF))
+		for idx, f := range farmsToExcludeIF {
+			farmsToExclude[idx] = uint32(f.(int))
+		}
+
+		strategy := mp["strategy"].(string)
+		if err := scheduler.ValidateStrategy(strategy); err != nil {
+			return nil, errors.Wrapf(err, "invalid request %s", mp["name"].(string))
+		}
+
 		reqs = append(reqs, scheduler.Request{
-			Name:           mp["name"].(string),
-			FarmId:         uint32(mp["farm_id"].(int)),
-			PublicConfig:   mp["public_config"].(bool),
-			PublicIpsCount: uint32(mp["public_ips_count"].(int)),
-			Certified:      mp["certified"].(bool),
-			Dedicated:      mp["dedicated"].(bool),
-			NodeExclude:    nodesToExclude,
+			Name:              mp["name"].(string),
+			FarmId:            uint32(mp["farm_id"].(int)),
+			PublicConfig:      mp["public_config"].(bool),
+			PublicIpsCount:    uint32(mp["public_ips_count"].(int)),
+			Certified:         mp["certified"].(bool),
+			FarmCertification: mp["farm_certification"].(string),
+			Dedicated:         mp["dedicated"].(bool),
+			NodeExclude:       nodesToExclude,
 			Capacity: scheduler.Capacity{
+				CRU: uint64(mp["cru"].(int)),
 				MRU: uint64(mp["mru"].(int)) * uint64(gridtypes.Megabyte),
 				HRU: uint64(mp["hru"].(int)) * uint64(gridtypes.Megabyte),
 				SRU: uint64(mp["sru"].(int)) * uint64(gridtypes.Megabyte),
 			},
-			Distinct: mp["distinct"].(bool),
+			Distinct:         mp["distinct"].(bool),
+			Strategy:         strategy,
+			Group:            mp["placement_group"].(string),
+			SameFarmAs:       mp["same_farm_as"].(string),
+			Country:          mp["country"].(string),
+			City:             mp["city"].(string),
+			IPv4:             mp["ipv4"].(bool),
+			IPv6:             mp["ipv6"].(bool),
+			MinZosVersion:    mp["min_zos_version"].(string),
+			RentedByMe:       mp["rented_by_me"].(bool),
+			PreferRentedByMe: mp["prefer_rented_by_me"].(bool),
+			FarmExclude:      farmsToExclude,
+			MaxMonthlyPrice:  mp["max_monthly_price"].(float64),
+		})
+	}
+	return reqs, nil
+}
+
+func parsePlacementGroups(d *schema.ResourceData) []scheduler.PlacementGroup {
+	groupsIfs := d.Get("placement_groups").([]interface{})
+	groups := make([]scheduler.PlacementGroup, 0, len(groupsIfs))
+	for _, g := range groupsIfs {
+		mp := g.(map[string]interface{})
+		groups = append(groups, scheduler.PlacementGroup{
+			Name:              mp["name"].(string),
+			DistinctFarms:     mp["distinct_farms"].(bool),
+			DistinctCountries: mp["distinct_countries"].(bool),
+			DistinctCities:    mp["distinct_cities"].(bool),
+			MaxPerNode:        uint32(mp["max_per_node"].(int)),
 		})
 	}
-	return reqs
+	return groups
+}
+
+// setExplanations updates the explanations of the requests scheduled by the scheduler, keeping the previous ones
+func setExplanations(d *schema.ResourceData, scheduler *scheduler.Scheduler) error {
+	explanations := d.Get("explanations").(map[string]interface{})
+	for name, explanation := range scheduler.Explanations() {
+		explanations[name] = explanation
+	}
+	return d.Set("explanations", explanations)
+}
+
+// setCosts updates the costs of the requests scheduled by the scheduler, keeping the previous costs of the assigned requests
+// and estimating the missing ones
+func setCosts(d *schema.ResourceData, scheduler *scheduler.Scheduler, reqs []scheduler.Request, assignment map[string]uint32) error {
+	costs := make(map[string]interface{})
+	for name, cost := range d.Get("costs").(map[string]interface{}) {
+		if _, ok := assignment[name]; ok {
+			costs[name] = cost
+		}
+	}
+	for name, cost := range scheduler.Costs() {
+		costs[name] = cost
+	}
+	for idx := range reqs {
+		r := &reqs[idx]
+		node, ok := assignment[r.Name]
+		if _, estimated := costs[r.Name]; !ok || estimated {
+			continue
+		}
+		cost, err := scheduler.EstimateCost(r, node)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't estimate the cost of request %s", r.Name)
+		}
+		costs[r.Name] = cost
+	}
+	return d.Set("costs", costs)
+}
+
+func newScheduler(d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient) scheduler.Scheduler {
+	farmerBotConfig := scheduler.DefaultFarmerBotConfig
+	farmerBotConfig.CallTimeout = tfPluginClient.RMBTimeout
+	farmerBotConfig.Timeout = uint32(d.Get("farmerbot_timeout").(int))
+	farmerBotConfig.GracePeriod = uint32(d.Get("farmerbot_grace_period").(int))
+	farmerBotConfig.PowerOnTimeout = time.Duration(d.Get("farmerbot_power_on_timeout").(int)) * time.Second
+
+	pricingPolicies := func(id uint32) (scheduler.PricingPolicy, error) {
+		policy, err := getPricingPolicy(tfPluginClient, id)
+		if err != nil {
+			return scheduler.PricingPolicy{}, err
+		}
+		return scheduler.NewPricingPolicy(policy), nil
+	}
+
+	scheduler := scheduler.NewScheduler(tfPluginClient.GridProxyClient, uint64(tfPluginClient.TwinID), tfPluginClient.RMB)
+	if seed, ok := d.GetOk("seed"); ok {
+		scheduler.SetSeed(int64(seed.(int)))
+	}
+	for _, group := range parsePlacementGroups(d) {
+		scheduler.AddPlacementGroup(group)
+	}
+	scheduler.SetFarmerBotConfig(farmerBotConfig)
+	scheduler.SetNodeClients(tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	scheduler.SetPricingPolicies(pricingPolicies)
+	return scheduler
+}
+
+// getPricingPolicy reads a pricing policy from the chain, the substrate client has no call for it
+func getPricingPolicy(tfPluginClient *deployer.TFPluginClient, id uint32) (substrate.PricingPolicy, error) {
+	var policy substrate.PricingPolicy
+	sub, ok := tfPluginClient.SubstrateConn.(*subi.SubstrateImpl)
+	if !ok {
+		return policy, fmt.Errorf("substrate connection %T doesn't support reading pricing policies", tfPluginClient.SubstrateConn)
+	}
+	cl, meta, err := sub.GetClient()
+	if err != nil {
+		return policy, err
+	}
+	bytes, err := types.Encode(id)
+	if err != nil {
+		return policy, errors.Wrap(err, "couldn't encode the pricing policy id")
+	}
+	key, err := types.CreateStorageKey(meta, "TfgridModule", "PricingPolicies", bytes, nil)
+	if err != nil {
+		return policy, errors.Wrap(err, "couldn't create the pricing policy query key")
+	}
+	raw, err := cl.RPC.State.GetStorageRawLatest(key)
+	if err != nil {
+		return policy, errors.Wrapf(err, "couldn't lookup pricing policy %d", id)
+	}
+	if len(*raw) == 0 {
+		return policy, errors.Wrapf(substrate.ErrNotFound, "pricing policy %d not found", id)
+	}
+	if err := types.Decode(*raw, &policy); err != nil {
+		return policy, errors.Wrapf(err, "couldn't decode pricing policy %d", id)
+	}
+	return policy, nil
 }
 
 func schedule(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -156,24 +440,159 @@ func schedule(ctx context.Context, d *schema.ResourceData, meta interface{}) dia
 	}
 	// read previously assigned nodes
 	assignment := parseAssignment(d)
-	reqs := parseRequests(d, assignment)
+	reqs, err := parseRequests(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-	scheduler := scheduler.NewScheduler(tfPluginClient.GridProxyClient, uint64(tfPluginClient.TwinID), tfPluginClient.RMB)
+	scheduler := newScheduler(d, tfPluginClient)
 	if err := scheduler.ProcessRequests(ctx, reqs, assignment); err != nil {
 		return diag.FromErr(err)
 	}
 
-	err := d.Set("nodes", assignment)
+	err = d.Set("nodes", assignment)
 	if err != nil {
 		return diag.FromErr(errors.Wrapf(err, "couldn't set nodes with %v", assignment))
 	}
+	if err := setExplanations(d, &scheduler); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set explanations"))
+	}
+	if err := setCosts(d, &scheduler, reqs, assignment); err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set costs"))
+	}
 	return nil
 
 }
 
-// ResourceSchedRead reads for schedule resource
+// ResourceSchedRead reads for schedule resource, checking the assigned nodes can still host their requests
 func ResourceSchedRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	return diag.Diagnostics{}
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into api client"))
+	}
+	assignment := parseAssignment(d)
+	reqs, err := parseRequests(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// the health check doesn't price the nodes, so it doesn't need the pricing policy from the chain
+	healthScheduler := scheduler.NewScheduler(tfPluginClient.GridProxyClient, uint64(tfPluginClient.TwinID), tfPluginClient.RMB)
+	healthScheduler.SetNodeClients(tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	broken, diags := checkAssignment(ctx, &healthScheduler, reqs, assignment)
+	if len(broken) == 0 {
+		return diags
+	}
+
+	names := make([]string, 0, len(broken))
+	for name := range broken {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	details := make([]string, 0, len(broken))
+	for _, name := range names {
+		details = append(details, fmt.Sprintf("request %s: %s", name, broken[name]))
+	}
+
+	if !d.Get("reschedule_on_failure").(bool) {
+		return append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "assigned nodes can't host their requests anymore",
+			Detail:   strings.Join(details, "\n"),
+		})
+	}
+
+	// the requests are only reassigned on apply, dropping them from the assignment plans it
+	for _, name := range names {
+		delete(assignment, name)
+	}
+	if err := d.Set("nodes", assignment); err != nil {
+		return append(diags, diag.FromErr(errors.Wrapf(err, "couldn't set nodes with %v", assignment))...)
+	}
+	return append(diags, diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  "the requests of the failing nodes are rescheduled on the next apply",
+		Detail:   strings.Join(details, "\n"),
+	})
+}
+
+// checkAssignment returns why the assigned nodes can't host their requests anymore, by request name.
+// The requests assigned to the same node are checked together, and the capacity of the twin's deployments
+// on the node stays available to them.
+func checkAssignment(ctx context.Context, sched *scheduler.Scheduler, reqs []scheduler.Request, assignment map[string]uint32) (map[string]string, diag.Diagnostics) {
+	names := make(map[uint32][]string)
+	combined := make(map[uint32]*scheduler.Request)
+	nodes := []uint32{}
+	for _, r := range reqs {
+		node, ok := assignment[r.Name]
+		if !ok {
+			continue
+		}
+		c, ok := combined[node]
+		if !ok {
+			c = &scheduler.Request{}
+			combined[node] = c
+			nodes = append(nodes, node)
+		}
+		// cpus are overprovisioned, so each request only needs the node to have its cpus
+		if r.Capacity.CRU > c.Capacity.CRU {
+			c.Capacity.CRU = r.Capacity.CRU
+		}
+		c.Capacity.MRU += r.Capacity.MRU
+		c.Capacity.SRU += r.Capacity.SRU
+		c.Capacity.HRU += r.Capacity.HRU
+		names[node] = append(names[node], r.Name)
+	}
+
+	var diags diag.Diagnostics
+	broken := make(map[string]string)
+	for _, node := range nodes {
+		share, err := sched.DeployedCapacity(ctx, node)
+		reason := ""
+		if err == nil {
+			reason, err = sched.CheckAssignment(combined[node], node, share)
+		}
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("couldn't check node %d of requests %s", node, strings.Join(names[node], ", ")),
+				Detail:   err.Error(),
+			})
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+		for _, name := range names[node] {
+			broken[name] = reason
+		}
+	}
+	return broken, diags
+}
+
+// planReschedule marks the assignment as changing if some requests aren't assigned to nodes,
+// like the ones of failing nodes dropped by ResourceSchedRead
+func planReschedule(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	assignment := d.Get("nodes").(map[string]interface{})
+	for _, r := range d.Get("requests").([]interface{}) {
+		request, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := assignment[request["name"].(string)]; ok {
+			continue
+		}
+		for _, key := range []string{"nodes", "explanations", "costs"} {
+			if err := d.SetNewComputed(key); err != nil {
+				return errors.Wrapf(err, "couldn't mark %s as computed", key)
+			}
+		}
+		return nil
+	}
+	return nil
 }
 
 // ResourceSchedCreate creates for schedule resource
@@ -5,7 +5,7 @@ import (
 	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 )
 
-// Capacity struct for capacity (MRU, SRU, HRU)
+// Capacity struct for capacity (MRU, SRU, HRU, CRU)
 type Capacity struct {
 	MRU uint64
 	SRU uint64
@@ -17,14 +17,30 @@ func (c *Capacity) consume(r *Request) {
 	c.MRU -= r.Capacity.MRU
 	c.HRU -= r.Capacity.HRU
 	c.SRU -= r.Capacity.SRU
+	c.CRU -= r.Capacity.CRU
+}
+
+func (c *Capacity) release(r *Request) {
+	c.MRU += r.Capacity.MRU
+	c.HRU += r.Capacity.HRU
+	c.SRU += r.Capacity.SRU
+	c.CRU += r.Capacity.CRU
 }
 
 func freeCapacity(node *proxyTypes.Node) Capacity {
 	var res Capacity
 
-	res.MRU = uint64(node.TotalResources.MRU) - uint64(node.UsedResources.MRU)
-	res.HRU = uint64(node.TotalResources.HRU) - uint64(node.UsedResources.HRU)
-	res.SRU = uint64(node.TotalResources.SRU) - uint64(node.UsedResources.SRU)
-	res.CRU = uint64(node.TotalResources.CRU) - uint64(node.UsedResources.CRU)
+	res.MRU = free(uint64(node.TotalResources.MRU), uint64(node.UsedResources.MRU))
+	res.HRU = free(uint64(node.TotalResources.HRU), uint64(node.UsedResources.HRU))
+	res.SRU = free(uint64(node.TotalResources.SRU), uint64(node.UsedResources.SRU))
+	res.CRU = free(node.TotalResources.CRU, node.UsedResources.CRU)
 	return res
 }
+
+// free returns the unused part of total, zos overprovisions CPUs so used can exceed total
+func free(total, used uint64) uint64 {
+	if used >= total {
+		return 0
+	}
+	return total - used
+}
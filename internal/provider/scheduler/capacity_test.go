@@ -13,11 +13,13 @@ var (
 			HRU: 1,
 			SRU: 2,
 			MRU: 3,
+			CRU: 1,
 		},
 		TotalResources: proxyTypes.Capacity{
 			HRU: 4,
 			SRU: 5,
 			MRU: 6,
+			CRU: 4,
 		},
 	}
 )
@@ -27,4 +29,26 @@ func TestFreeCapacity(t *testing.T) {
 	assert.Equal(t, cap.HRU, uint64(3), "hru")
 	assert.Equal(t, cap.SRU, uint64(3), "sru")
 	assert.Equal(t, cap.MRU, uint64(3), "mru")
+	assert.Equal(t, cap.CRU, uint64(3), "cru")
+}
+
+func TestFreeCapacityOverprovisionedCPU(t *testing.T) {
+	n := node
+	n.UsedResources.CRU = 8
+	cap := freeCapacity(&n)
+	assert.Equal(t, cap.CRU, uint64(0), "cru")
+	assert.Equal(t, cap.MRU, uint64(3), "mru")
+}
+
+func TestConsume(t *testing.T) {
+	cap := freeCapacity(&node)
+	cap.consume(&Request{
+		Capacity: Capacity{
+			CRU: 2,
+			MRU: 1,
+			SRU: 1,
+			HRU: 1,
+		},
+	})
+	assert.Equal(t, cap, Capacity{CRU: 1, MRU: 2, SRU: 2, HRU: 2})
 }
@@ -0,0 +1,69 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Explanation describes how the scheduler searched a node for a request
+type Explanation struct {
+	// Considered is the count of the nodes checked against the request
+	Considered int
+	// Rejections counts the rejected nodes by the request field they didn't fulfil
+	Rejections map[string]int
+	// Node is the picked node, 0 if none was found
+	Node uint32
+	// FarmerBot is true if the node was picked by the farm's farmer bot
+	FarmerBot bool
+}
+
+func newExplanation() *Explanation {
+	return &Explanation{
+		Rejections: make(map[string]int),
+	}
+}
+
+func (e *Explanation) String() string {
+	if e == nil {
+		return "no nodes were considered"
+	}
+	if e.FarmerBot {
+		return fmt.Sprintf("node %d was picked by the farmer bot", e.Node)
+	}
+
+	fields := make([]string, 0, len(e.Rejections))
+	for field := range e.Rejections {
+		fields = append(fields, field)
+	}
+	// most rejecting fields first
+	sort.Slice(fields, func(i, j int) bool {
+		if e.Rejections[fields[i]] != e.Rejections[fields[j]] {
+			return e.Rejections[fields[i]] > e.Rejections[fields[j]]
+		}
+		return fields[i] < fields[j]
+	})
+	rejections := make([]string, 0, len(fields))
+	for _, field := range fields {
+		rejections = append(rejections, fmt.Sprintf("%s: %d", field, e.Rejections[field]))
+	}
+
+	res := fmt.Sprintf("considered %d nodes", e.Considered)
+	if len(rejections) != 0 {
+		res += fmt.Sprintf(", rejected by %s", strings.Join(rejections, ", "))
+	}
+	if e.Node != 0 {
+		res += fmt.Sprintf(", picked node %d", e.Node)
+	}
+	return res
+}
+
+// Explanations returns how the scheduler searched a node for each request it scheduled
+func (n *Scheduler) Explanations() map[string]string {
+	res := make(map[string]string, len(n.explanations))
+	for name, explanation := range n.explanations {
+		res[name] = explanation.String()
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func TestExplanations(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	// the grid proxy only filters the nodes on their total cpus, the free ones are checked by the scheduler
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, TotalResources: proxyTypes.Capacity{CRU: 8}, UsedResources: proxyTypes.Capacity{CRU: 6}})
+	proxy.AddNode(2, proxyTypes.Node{NodeID: 2, FarmID: 1, TotalResources: proxyTypes.Capacity{CRU: 8}, UsedResources: proxyTypes.Capacity{CRU: 6}})
+	proxy.AddNode(3, proxyTypes.Node{NodeID: 3, FarmID: 1, TotalResources: proxyTypes.Capacity{CRU: 8}})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, PublicIps: []proxyTypes.PublicIP{{IP: "a"}}})
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{
+		Name:     "fits",
+		Capacity: Capacity{CRU: 4},
+		Strategy: BinPackingStrategy,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), nodeID)
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		Name:           "too-big",
+		Capacity:       Capacity{CRU: 6},
+		PublicIpsCount: 1,
+	})
+	assert.EqualError(t, err, "couldn't find a node satisfying the given requirements, considered 3 nodes, rejected by cru: 3")
+
+	assert.Equal(t, map[string]string{
+		"fits":    "considered 3 nodes, rejected by cru: 2, picked node 3",
+		"too-big": "considered 3 nodes, rejected by cru: 3",
+	}, scheduler.Explanations())
+}
+
+func TestExplanationString(t *testing.T) {
+	var explanation *Explanation
+	assert.Equal(t, "no nodes were considered", explanation.String())
+
+	explanation = &Explanation{
+		Considered: 5,
+		Rejections: map[string]int{"dedicated": 1, "public_ips_count": 2, "farm_id": 2},
+	}
+	assert.Equal(t, "considered 5 nodes, rejected by farm_id: 2, public_ips_count: 2, dedicated: 1", explanation.String())
+
+	explanation = &Explanation{Node: 4, FarmerBot: true}
+	assert.Equal(t, "node 4 was picked by the farmer bot", explanation.String())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +17,9 @@ const (
 	FarmerBotVersionAction  = "farmerbot.farmmanager.version"
 	FarmerBotFindNodeAction = "farmerbot.nodemanager.findnode"
 	FarmerBotRMBFunction    = "execute_job"
+
+	farmerBotNodeIDKey  = "nodeid"
+	farmerBotVersionKey = "version"
 )
 
 type FarmerBotAction struct {
@@ -57,10 +61,70 @@ type Params struct {
 	Value interface{} `json:"value"`
 }
 
+// farmerBotFound is the answer of a farmer bot to a find node job
+type farmerBotFound struct {
+	node uint32
+	err  error
+}
+
+// farmerBotRejection is the error of a node found by the farmer bot that doesn't fulfil the request,
+// the farmer bot only knows about some of the request's fields
+type farmerBotRejection struct {
+	node  uint32
+	field string
+}
+
+func (e farmerBotRejection) Error() string {
+	return fmt.Sprintf("node %d found by the farmer bot doesn't fulfil the request's %s", e.node, e.field)
+}
+
+// FarmerBotConfig configures the jobs sent to the farmer bots
+type FarmerBotConfig struct {
+	// CallTimeout is how long to wait for a farmer bot to answer a job
+	CallTimeout time.Duration
+	// Timeout of the farmer bot jobs in seconds, it's how long the bot keeps running a job
+	Timeout uint32
+	// GracePeriod of the farmer bot jobs in seconds
+	GracePeriod uint32
+	// PowerOnTimeout is how long to wait for a node powered on by the farmer bot to be up
+	PowerOnTimeout time.Duration
+	// PollInterval is the interval between two checks of a powering on node's status
+	PollInterval time.Duration
+}
+
+// DefaultFarmerBotConfig is the default configuration of the farmer bot jobs
+var DefaultFarmerBotConfig = FarmerBotConfig{
+	CallTimeout:    10 * time.Second,
+	Timeout:        6000,
+	GracePeriod:    0,
+	PowerOnTimeout: 10 * time.Minute,
+	PollInterval:   10 * time.Second,
+}
+
+// SetFarmerBotConfig sets the configuration of the farmer bot jobs
+func (n *Scheduler) SetFarmerBotConfig(config FarmerBotConfig) {
+	n.farmerBot = config
+}
+
+// callFarmerBot sends a job to the farmer bot and fails if the job failed
+func (n *Scheduler) callFarmerBot(ctx context.Context, farmerTwinID uint32, data FarmerBotAction) (FarmerBotAction, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.farmerBot.CallTimeout)
+	defer cancel()
+
+	var output FarmerBotAction
+	if err := n.rmbClient.Call(ctx, farmerTwinID, FarmerBotRMBFunction, data, &output); err != nil {
+		return output, err
+	}
+	if output.Error != "" {
+		return output, fmt.Errorf("farmer bot job %s failed: %s", data.Action, output.Error)
+	}
+	return output, nil
+}
+
 func (s *Scheduler) hasFarmerBot(ctx context.Context, farmID uint32) bool {
-	args := []Args{}
-	params := []Params{}
-	data := buildFarmerBotAction(farmID, uint32(s.twinID), args, params, FarmerBotVersionAction)
+	if has, ok := s.farmerBots[farmID]; ok {
+		return has
+	}
 
 	info, err := s.getFarmInfo(farmID)
 	if err != nil {
@@ -68,13 +132,15 @@ func (s *Scheduler) hasFarmerBot(ctx context.Context, farmID uint32) bool {
 	}
 
 	dst := info.farmerTwinID
-	var output FarmerBotAction
-
-	err = s.rmbClient.Call(ctx, dst, FarmerBotRMBFunction, data, &output)
+	data := s.buildFarmerBotAction(dst, uint32(s.twinID), []Args{}, []Params{}, FarmerBotVersionAction)
+	output, err := s.callFarmerBot(ctx, dst, data)
 	if err != nil {
 		log.Printf("error while pinging farmerbot on farm %d with farmer twin %d. %s", farmID, dst, err.Error())
+	} else if version, ok := resultParam(output, farmerBotVersionKey); ok {
+		log.Printf("farm %d has farmerbot version %v", farmID, version)
 	}
 
+	s.farmerBots[farmID] = err == nil
 	return err == nil
 }
 
@@ -83,29 +149,172 @@ func (n *Scheduler) farmerBotSchedule(ctx context.Context, r *Request) (uint32,
 	if err != nil {
 		return 0, errors.Wrapf(err, "failed to get farm %d info", r.FarmId)
 	}
+	if r.FarmCertification != "" && !strings.EqualFold(info.certificationType, r.FarmCertification) {
+		return 0, fmt.Errorf("farm %d certification is %s, not %s", r.FarmId, info.certificationType, r.FarmCertification)
+	}
+	// the farmer bot doesn't know about the public ips reserved by the previous requests
+	if uint64(r.PublicIpsCount) > info.freeIPs {
+		return 0, fmt.Errorf("farm %d has %d free public ips left, request %s needs %d", r.FarmId, info.freeIPs, r.Name, r.PublicIpsCount)
+	}
+	nodeID, err := n.farmerBotFindNode(ctx, r, info.farmerTwinID)
+	if err != nil {
+		return 0, err
+	}
+
+	node, err := n.farmerBotNode(nodeID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldn't check node %d found by the farmer bot", nodeID)
+	}
+	// the zos version is only known once the node is up
+	others := *r
+	others.MinZosVersion = ""
+	if rejection := node.localRejection(&others, n.twinID); rejection != "" {
+		return 0, farmerBotRejection{node: nodeID, field: rejection}
+	}
+
+	if node.Node.Status != statusUp {
+		if err := n.waitNodeUp(ctx, nodeID); err != nil {
+			return 0, err
+		}
+		node.Node.Status = statusUp
+	}
+	// the node is up, so it's known like the listed nodes and its capacity is consumed by the requests
+	n.nodes[nodeID] = node
+
+	if r.MinZosVersion != "" {
+		if !node.ZosVersionLoaded {
+			version, err := n.zosVersion(ctx, nodeID)
+			if err != nil {
+				log.Printf("couldn't get node %d zos version: %s", nodeID, err)
+			}
+			node.ZosVersion = version
+			node.ZosVersionLoaded = true
+			n.nodes[nodeID] = node
+		}
+		if rejection := node.localRejection(r, n.twinID); rejection != "" {
+			return 0, farmerBotRejection{node: nodeID, field: rejection}
+		}
+	}
+
+	// the farmer bot doesn't know about the prices
+	if r.MaxMonthlyPrice != 0 {
+		if cost := n.requestCost(r, &node); cost > r.MaxMonthlyPrice {
+			return 0, fmt.Errorf("node %d found by the farmer bot costs %.2f USD per month, more than the max monthly price %.2f", nodeID, cost, r.MaxMonthlyPrice)
+		}
+	}
+
+	n.explanations[r.Name] = &Explanation{
+		Node:       nodeID,
+		FarmerBot:  true,
+		Rejections: map[string]int{},
+	}
+	return nodeID, nil
+}
+
+// farmerBotFindNode asks the farmer bot of the request's farm for a node.
+// The answers are kept, so the backtracking of the placement constraints doesn't send the same job again.
+func (n *Scheduler) farmerBotFindNode(ctx context.Context, r *Request, farmerTwinID uint32) (uint32, error) {
 	params := buildFarmerBotParams(r)
+	key := fmt.Sprintf("%d %v", r.FarmId, params)
+	if found, ok := n.farmerBotNodes[key]; ok {
+		return found.node, found.err
+	}
+
+	var found farmerBotFound
 	args := buildFarmerBotArgs(r)
-	data := buildFarmerBotAction(info.farmerTwinID, uint32(n.twinID), args, params, FarmerBotFindNodeAction)
-	output := FarmerBotAction{}
+	data := n.buildFarmerBotAction(farmerTwinID, uint32(n.twinID), args, params, FarmerBotFindNodeAction)
+	if output, err := n.callFarmerBot(ctx, farmerTwinID, data); err != nil {
+		found.err = errors.Wrapf(err, "couldn't find a node with the farmer bot of farm %d", r.FarmId)
+	} else if found.node, err = parseFarmerBotNodeID(output); err != nil {
+		found.err = errors.Wrapf(err, "cannot find an eligible node on farm %d", r.FarmId)
+	} else {
+		log.Printf("got a node with id %d", found.node)
+	}
+	n.farmerBotNodes[key] = found
+	return found.node, found.err
+}
 
-	err = n.rmbClient.Call(ctx, info.farmerTwinID, FarmerBotRMBFunction, data, &output)
+// farmerBotNode returns the scheduling information of a node found by the farmer bot,
+// the node may be asleep so it's not always listed by the scheduler
+func (n *Scheduler) farmerBotNode(nodeID uint32) (nodeInfo, error) {
+	if node, ok := n.nodes[nodeID]; ok {
+		return node, nil
+	}
+	details, err := n.nodeDetails(nodeID)
 	if err != nil {
-		return 0, err
+		return nodeInfo{}, err
 	}
-	if len(output.Result.Params) < 1 {
-		return 0, fmt.Errorf("cannot find an eligible node on farm %d", r.FarmId)
+	capacity := freeCapacity(&details)
+	return nodeInfo{FreeCapacity: &capacity, Node: details}, nil
+}
+
+// resultParam returns the value of a farmer bot job result param
+func resultParam(output FarmerBotAction, key string) (interface{}, bool) {
+	for _, param := range output.Result.Params {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// parseFarmerBotNodeID reads the node id from the result of a find node job
+func parseFarmerBotNodeID(output FarmerBotAction) (uint32, error) {
+	value, ok := resultParam(output, farmerBotNodeIDKey)
+	if !ok {
+		return 0, fmt.Errorf("farmer bot result has no %s", farmerBotNodeIDKey)
+	}
+
+	var nodeID uint64
+	var err error
+	switch v := value.(type) {
+	case string:
+		nodeID, err = strconv.ParseUint(v, 10, 32)
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v > math.MaxUint32 {
+			err = fmt.Errorf("%v is not a node id", v)
+		}
+		nodeID = uint64(v)
+	default:
+		err = fmt.Errorf("unexpected type %T", value)
 	}
-	nodeId, err := strconv.ParseUint(output.Result.Params[0].Value.(string), 10, 32)
 	if err != nil {
-		return 0, err
+		return 0, errors.Wrapf(err, "invalid farmer bot %s %v", farmerBotNodeIDKey, value)
 	}
-	log.Printf("got a node with id %d", nodeId)
-	return uint32(nodeId), nil
+	if nodeID == 0 {
+		return 0, fmt.Errorf("farmer bot returned node id 0")
+	}
+	return uint32(nodeID), nil
 }
 
+// waitNodeUp waits for a node powered on by the farmer bot to be up in the grid proxy
+func (n *Scheduler) waitNodeUp(ctx context.Context, nodeID uint32) error {
+	ctx, cancel := context.WithTimeout(ctx, n.farmerBot.PowerOnTimeout)
+	defer cancel()
+
+	for {
+		node, err := n.gridProxyClient.Node(nodeID)
+		if err != nil {
+			log.Printf("couldn't get node %d status: %s", nodeID, err)
+		} else if node.Status == statusUp {
+			return nil
+		} else {
+			log.Printf("waiting for node %d to be up, it's %s", nodeID, node.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node %d found by the farmer bot isn't up after %s", nodeID, n.farmerBot.PowerOnTimeout)
+		case <-time.After(n.farmerBot.PollInterval):
+		}
+	}
+}
+
+// buildFarmerBotArgs builds the arguments of a find node job, they're empty since the farmer bot reads the request from the params
 func buildFarmerBotArgs(r *Request) []Args {
 	return []Args{}
 }
+
 func buildFarmerBotParams(r *Request) []Params {
 	params := []Params{}
 	if r.Capacity.HRU != 0 {
@@ -147,7 +356,7 @@ func buildFarmerBotParams(r *Request) []Params {
 	return params
 }
 
-func buildFarmerBotAction(farmerTwinID uint32, sourceTwinID uint32, args []Args, params []Params, action string) FarmerBotAction {
+func (n *Scheduler) buildFarmerBotAction(farmerTwinID uint32, sourceTwinID uint32, args []Args, params []Params, action string) FarmerBotAction {
 	return FarmerBotAction{
 		Guid:   uuid.NewString(),
 		TwinID: farmerTwinID,
@@ -163,9 +372,9 @@ func buildFarmerBotAction(farmerTwinID uint32, sourceTwinID uint32, args []Args,
 		State:        "init",
 		Start:        uint64(time.Now().Unix()),
 		End:          0,
-		GracePeriod:  0,
+		GracePeriod:  n.farmerBot.GracePeriod,
 		Error:        "",
-		Timeout:      6000,
+		Timeout:      n.farmerBot.Timeout,
 		SourceTwinID: sourceTwinID,
 		Dependencies: []string{},
 	}
@@ -0,0 +1,209 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func farmerBotProxy() *GridProxyClientMock {
+	proxy := &GridProxyClientMock{poweringOn: map[uint32]int{}}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:         1,
+		FarmID:         1,
+		Status:         "up",
+		TotalResources: proxyTypes.Capacity{CRU: 4, MRU: 8},
+	})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 7, PublicIps: []proxyTypes.PublicIP{{IP: "a"}}})
+	return proxy
+}
+
+func TestParseFarmerBotNodeID(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected uint32
+		err      bool
+	}{
+		{"12", 12, false},
+		{float64(12), 12, false},
+		{"a", 0, true},
+		{float64(1.5), 0, true},
+		{float64(-1), 0, true},
+		{"0", 0, true},
+		{true, 0, true},
+	}
+	for _, c := range cases {
+		nodeID, err := parseFarmerBotNodeID(FarmerBotAction{
+			Result: FarmerBotArgs{Params: []Params{{Key: farmerBotNodeIDKey, Value: c.value}}},
+		})
+		assert.Equal(t, c.err, err != nil, "value %v", c.value)
+		assert.Equal(t, c.expected, nodeID, "value %v", c.value)
+	}
+
+	_, err := parseFarmerBotNodeID(FarmerBotAction{})
+	assert.Error(t, err, "the result has no node id")
+}
+
+func TestFarmerBotInvalidResult(t *testing.T) {
+	rmbClient := &RMBClientMock{hasFarmerBot: true, nodeIDResult: "node"}
+	scheduler := NewScheduler(farmerBotProxy(), 1, rmbClient)
+	_, err := scheduler.Schedule(context.Background(), &Request{Name: "req", FarmId: 1})
+	assert.ErrorContains(t, err, "invalid farmer bot nodeid node")
+}
+
+func TestFarmerBotPowerOn(t *testing.T) {
+	proxy := farmerBotProxy()
+	proxy.poweringOn[1] = 2
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{hasFarmerBot: true, nodeID: 1})
+	scheduler.SetFarmerBotConfig(FarmerBotConfig{
+		CallTimeout:    time.Second,
+		Timeout:        60,
+		PowerOnTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+	})
+
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", FarmId: 1})
+	assert.NoError(t, err, "the node is up after powering on")
+	assert.Equal(t, uint32(1), nodeID)
+	assert.Equal(t, 0, proxy.poweringOn[1], "the node status should be checked until it's up")
+}
+
+func TestFarmerBotPowerOnTimeout(t *testing.T) {
+	proxy := farmerBotProxy()
+	proxy.poweringOn[1] = 1000
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{hasFarmerBot: true, nodeID: 1})
+	scheduler.SetFarmerBotConfig(FarmerBotConfig{
+		CallTimeout:    time.Second,
+		Timeout:        60,
+		PowerOnTimeout: 20 * time.Millisecond,
+		PollInterval:   time.Millisecond,
+	})
+
+	_, err := scheduler.Schedule(context.Background(), &Request{Name: "req", FarmId: 1})
+	assert.ErrorContains(t, err, "node 1 found by the farmer bot isn't up")
+}
+
+func TestFarmerBotJobs(t *testing.T) {
+	rmbClient := &RMBClientMock{hasFarmerBot: true, nodeID: 1}
+	scheduler := NewScheduler(farmerBotProxy(), 3, rmbClient)
+	scheduler.SetFarmerBotConfig(FarmerBotConfig{
+		CallTimeout:    time.Second,
+		Timeout:        120,
+		GracePeriod:    30,
+		PowerOnTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+	})
+
+	_, err := scheduler.Schedule(context.Background(), &Request{
+		Name:           "req",
+		FarmId:         1,
+		Capacity:       Capacity{CRU: 2, MRU: 4},
+		NodeExclude:    []uint32{5},
+		PublicIpsCount: 1,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rmbClient.jobs, 2, "the version handshake and the find node jobs should be sent")
+
+	version, findNode := rmbClient.jobs[0], rmbClient.jobs[1]
+	assert.Equal(t, FarmerBotVersionAction, version.Action)
+	assert.Equal(t, uint32(7), version.TwinID, "jobs are sent to the farmer twin")
+	assert.Equal(t, FarmerBotFindNodeAction, findNode.Action)
+	assert.Equal(t, uint32(120), findNode.Timeout)
+	assert.Equal(t, uint32(30), findNode.GracePeriod)
+	assert.Equal(t, uint32(3), findNode.SourceTwinID)
+
+	// the request is only sent in the params, the format read by the farmer bot
+	assert.Empty(t, findNode.Args.Args)
+	assert.ElementsMatch(t, []Params{
+		{Key: "required_cru", Value: uint64(2)},
+		{Key: "required_mru", Value: uint64(4)},
+		{Key: "node_exclude", Value: "[5]"},
+		{Key: "public_ips", Value: uint32(1)},
+	}, findNode.Args.Params)
+}
+
+func TestFarmerBotCallTimeout(t *testing.T) {
+	rmbClient := &blockingRMB{}
+	scheduler := NewScheduler(farmerBotProxy(), 3, rmbClient)
+	scheduler.SetFarmerBotConfig(FarmerBotConfig{
+		CallTimeout:    20 * time.Millisecond,
+		Timeout:        6000,
+		PowerOnTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+	})
+
+	start := time.Now()
+	_, err := scheduler.callFarmerBot(context.Background(), 7, scheduler.buildFarmerBotAction(7, 3, []Args{}, []Params{}, FarmerBotVersionAction))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "the call doesn't wait for the job timeout")
+}
+
+// blockingRMB never answers, until the call is canceled
+type blockingRMB struct{}
+
+func (b *blockingRMB) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestFarmerBotFlows(t *testing.T) {
+	cases := []struct {
+		name     string
+		request  Request
+		expected uint32
+		woken    bool
+		findNode bool
+	}{
+		{"picks an up node", Request{FarmId: 1, Capacity: Capacity{CRU: 2}}, 1, false, true},
+		{"wakes a sleeping node", Request{FarmId: 1, Capacity: Capacity{CRU: 8}}, 2, true, true},
+		{"skips excluded nodes", Request{FarmId: 1, NodeExclude: []uint32{1}}, 2, true, true},
+		{"skips down nodes", Request{FarmId: 1, Capacity: Capacity{CRU: 32}}, 0, false, true},
+		{"no node with the resources", Request{FarmId: 1, Capacity: Capacity{CRU: 100}}, 0, false, true},
+		{"farm without a bot", Request{FarmId: 2}, 4, false, false},
+		{"falls back on a node in another country", Request{FarmId: 1, Country: "EG"}, 5, false, true},
+		{"no node in the country", Request{FarmId: 1, Country: "FR"}, 0, false, true},
+		{"falls back on a node without the certification", Request{FarmId: 1, Certified: true}, 5, false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			proxy := &GridProxyClientMock{}
+			proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 11})
+			proxy.AddFarm(proxyTypes.Farm{FarmID: 2, TwinID: 12})
+			proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, Status: "up", Country: "BE", TotalResources: proxyTypes.Capacity{CRU: 4}})
+			proxy.AddNode(2, proxyTypes.Node{NodeID: 2, FarmID: 1, Status: "standby", TotalResources: proxyTypes.Capacity{CRU: 16}})
+			proxy.AddNode(3, proxyTypes.Node{NodeID: 3, FarmID: 1, Status: "down", TotalResources: proxyTypes.Capacity{CRU: 64}})
+			proxy.AddNode(4, proxyTypes.Node{NodeID: 4, FarmID: 2, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 4}})
+			proxy.AddNode(5, proxyTypes.Node{NodeID: 5, FarmID: 1, Status: "up", Country: "EG", CertificationType: "Certified", TotalResources: proxyTypes.Capacity{CRU: 4}})
+			bots := &RMBClientMock{proxy: proxy, farms: map[uint32]int{11: 1}, powerOnChecks: 3}
+
+			scheduler := NewScheduler(proxy, 1, bots)
+			scheduler.SetFarmerBotConfig(FarmerBotConfig{
+				CallTimeout:    time.Second,
+				Timeout:        60,
+				PowerOnTimeout: time.Second,
+				PollInterval:   time.Millisecond,
+			})
+			c.request.Name = "req"
+			nodeID, err := scheduler.Schedule(context.Background(), &c.request)
+			if c.expected == 0 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, c.expected, nodeID)
+			}
+
+			findNode := false
+			for _, job := range bots.jobs {
+				findNode = findNode || job.Action == FarmerBotFindNodeAction
+			}
+			assert.Equal(t, c.findNode, findNode)
+			if c.woken {
+				assert.Equal(t, "up", proxy.nodes[nodeID-1].Status)
+				assert.Equal(t, 0, proxy.poweringOn[nodeID], "the scheduler should wait for the node to be up")
+			}
+		})
+	}
+}
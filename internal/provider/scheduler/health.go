@@ -0,0 +1,122 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+
+	contractsPageSize = 100
+)
+
+var (
+	contractStateCreated = "Created"
+	contractTypeNode     = "node"
+)
+
+// CheckAssignment returns why the node assigned to a request can't host it anymore, empty if it still can.
+// A node missing from the grid can't host the request either.
+//
+// share is the capacity already deployed on the node for the request, see DeployedCapacity. It's counted in the node's
+// used capacity but stays available to the request, so the request capacity is checked against the free capacity plus share.
+// A node using more than its total capacity lost some of it, but zos overprovisions CPUs, so they're checked against the total.
+func (n *Scheduler) CheckAssignment(r *Request, nodeID uint32, share Capacity) (string, error) {
+	node, err := n.gridProxyClient.Node(nodeID)
+	if err != nil {
+		exists, existsErr := n.nodeExists(nodeID)
+		if existsErr == nil && !exists {
+			return fmt.Sprintf("node %d doesn't exist", nodeID), nil
+		}
+		return "", errors.Wrapf(err, "couldn't get node %d from the grid proxy", nodeID)
+	}
+
+	// nodes in standby are woken up by the farmer bot when they're needed
+	if node.Status == statusDown {
+		return fmt.Sprintf("node %d is down", nodeID), nil
+	}
+	if node.RentedByTwinID != 0 && uint64(node.RentedByTwinID) != n.twinID {
+		return fmt.Sprintf("node %d is rented by twin %d", nodeID, node.RentedByTwinID), nil
+	}
+
+	total, used := node.Capacity.Total, node.Capacity.Used
+	if r.Capacity.CRU > total.CRU ||
+		!fits(r.Capacity.MRU, uint64(total.MRU), uint64(used.MRU), share.MRU) ||
+		!fits(r.Capacity.SRU, uint64(total.SRU), uint64(used.SRU), share.SRU) ||
+		!fits(r.Capacity.HRU, uint64(total.HRU), uint64(used.HRU), share.HRU) {
+		return fmt.Sprintf("node %d doesn't have enough capacity", nodeID), nil
+	}
+	return "", nil
+}
+
+// fits checks the requested amount against the free amount plus the share already used by the request
+func fits(requested, total, used, share uint64) bool {
+	return requested == 0 || requested+used <= total+share
+}
+
+// DeployedCapacity returns the capacity used on a node by the deployments of the scheduler's twin
+func (n *Scheduler) DeployedCapacity(ctx context.Context, nodeID uint32) (Capacity, error) {
+	var deployed Capacity
+	if n.nodeClients == nil {
+		return deployed, errors.New("no node clients are set")
+	}
+
+	twinID, node := n.twinID, uint64(nodeID)
+	filter := proxyTypes.ContractFilter{TwinID: &twinID, NodeID: &node, Type: &contractTypeNode, State: &contractStateCreated}
+	var contracts []proxyTypes.Contract
+	for page := uint64(1); ; page++ {
+		res, _, err := n.gridProxyClient.Contracts(filter, proxyTypes.Limit{Size: contractsPageSize, Page: page})
+		if err != nil {
+			return deployed, errors.Wrapf(err, "couldn't list the contracts on node %d", nodeID)
+		}
+		contracts = append(contracts, res...)
+		if len(res) < contractsPageSize {
+			break
+		}
+	}
+	if len(contracts) == 0 {
+		return deployed, nil
+	}
+
+	nodeClient, err := n.nodeClients.GetNodeClient(n.sub, nodeID)
+	if err != nil {
+		return deployed, errors.Wrapf(err, "couldn't get node %d client", nodeID)
+	}
+	for _, contract := range contracts {
+		dl, err := nodeClient.DeploymentGet(ctx, uint64(contract.ContractID))
+		if err != nil {
+			return deployed, errors.Wrapf(err, "couldn't get the deployment of contract %d", contract.ContractID)
+		}
+		for _, wl := range dl.Workloads {
+			if wl.Result.State == gridtypes.StateDeleted {
+				continue
+			}
+			capacity, err := wl.Capacity()
+			if err != nil {
+				return deployed, errors.Wrapf(err, "couldn't get the capacity of workload %s of contract %d", wl.Name, contract.ContractID)
+			}
+			deployed.CRU += capacity.CRU
+			deployed.MRU += uint64(capacity.MRU)
+			deployed.SRU += uint64(capacity.SRU)
+			deployed.HRU += uint64(capacity.HRU)
+		}
+	}
+	return deployed, nil
+}
+
+// nodeExists tells apart a node missing from the grid proxy from a failure to get it
+func (n *Scheduler) nodeExists(nodeID uint32) (bool, error) {
+	id := uint64(nodeID)
+	nodes, _, err := n.gridProxyClient.Nodes(proxyTypes.NodeFilter{NodeID: &id}, proxyTypes.Limit{Size: 1, Page: 1})
+	if err != nil {
+		return false, err
+	}
+	return len(nodes) != 0, nil
+}
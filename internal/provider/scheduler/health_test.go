@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+func TestCheckAssignment(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:         1,
+		Status:         "up",
+		TotalResources: proxyTypes.Capacity{CRU: 4, MRU: 8},
+		UsedResources:  proxyTypes.Capacity{CRU: 4, MRU: 8},
+	})
+	proxy.AddNode(2, proxyTypes.Node{NodeID: 2, Status: "down"})
+	proxy.AddNode(3, proxyTypes.Node{NodeID: 3, Status: "standby", RentedByTwinID: 5})
+	proxy.AddNode(4, proxyTypes.Node{NodeID: 4, Status: "up", RentedByTwinID: 1})
+	// node 5 lost half of its disks
+	proxy.AddNode(5, proxyTypes.Node{
+		NodeID:         5,
+		Status:         "up",
+		TotalResources: proxyTypes.Capacity{CRU: 2, SRU: 100},
+		UsedResources:  proxyTypes.Capacity{CRU: 4, SRU: 150},
+	})
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+
+	cases := []struct {
+		node     uint32
+		request  Request
+		share    Capacity
+		expected string
+	}{
+		// the used capacity is the request's own deployment
+		{1, Request{Capacity: Capacity{CRU: 4, MRU: 8}}, Capacity{CRU: 4, MRU: 8}, ""},
+		{1, Request{Capacity: Capacity{CRU: 5}}, Capacity{CRU: 4}, "node 1 doesn't have enough capacity"},
+		// the used capacity belongs to other deployments
+		{1, Request{Capacity: Capacity{MRU: 8}}, Capacity{}, "node 1 doesn't have enough capacity"},
+		// the request grew by more than the free capacity
+		{1, Request{Capacity: Capacity{MRU: 8}}, Capacity{MRU: 4}, "node 1 doesn't have enough capacity"},
+		{2, Request{}, Capacity{}, "node 2 is down"},
+		{3, Request{}, Capacity{}, "node 3 is rented by twin 5"},
+		{4, Request{}, Capacity{}, ""},
+		// cpus are overprovisioned, but disks aren't
+		{5, Request{Capacity: Capacity{CRU: 2}}, Capacity{CRU: 2}, ""},
+		{5, Request{Capacity: Capacity{SRU: 50}}, Capacity{SRU: 50}, "node 5 doesn't have enough capacity"},
+		{10, Request{}, Capacity{}, "node 10 doesn't exist"},
+	}
+	for _, c := range cases {
+		reason, err := scheduler.CheckAssignment(&c.request, c.node, c.share)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, reason, "node %d, request %+v", c.node, c.request)
+	}
+}
+
+func TestDeployedCapacity(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:         1,
+		TwinID:         21,
+		Status:         "up",
+		TotalResources: proxyTypes.Capacity{CRU: 4, MRU: 16 * gridtypes.Gigabyte, SRU: 20 * gridtypes.Gigabyte},
+		UsedResources:  proxyTypes.Capacity{CRU: 4, MRU: 16 * gridtypes.Gigabyte, SRU: 20 * gridtypes.Gigabyte},
+	})
+	contract := func(id, twinID uint, state string) proxyTypes.Contract {
+		return proxyTypes.Contract{ContractID: id, TwinID: twinID, State: state, Type: "node", Details: proxyTypes.NodeContractDetails{NodeID: 1}}
+	}
+	proxy.contracts = []proxyTypes.Contract{
+		contract(10, 1, "Created"),
+		contract(11, 2, "Created"),
+		contract(12, 1, "Deleted"),
+	}
+	vm := func(cpu uint8, memory, size gridtypes.Unit, state gridtypes.ResultState) gridtypes.Workload {
+		return gridtypes.Workload{
+			Type: zos.ZMachineType,
+			Name: "vm",
+			Data: gridtypes.MustMarshal(zos.ZMachine{
+				Size:            size,
+				ComputeCapacity: zos.MachineCapacity{CPU: cpu, Memory: memory},
+			}),
+			Result: gridtypes.Result{State: state},
+		}
+	}
+	rmbClient := &RMBClientMock{deployments: map[uint64]gridtypes.Deployment{
+		10: {Workloads: []gridtypes.Workload{
+			vm(2, 8*gridtypes.Gigabyte, 10*gridtypes.Gigabyte, gridtypes.StateOk),
+			vm(1, 2*gridtypes.Gigabyte, 10*gridtypes.Gigabyte, gridtypes.StateDeleted),
+		}},
+		11: {Workloads: []gridtypes.Workload{vm(2, 8*gridtypes.Gigabyte, 10*gridtypes.Gigabyte, gridtypes.StateOk)}},
+	}}
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	scheduler.SetNodeClients(nodeClientsMock{proxy: proxy, rmb: rmbClient}, nil)
+
+	gb := uint64(gridtypes.Gigabyte)
+	share, err := scheduler.DeployedCapacity(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Capacity{CRU: 2, MRU: 8 * gb, SRU: 10 * gb}, share, "only the live workloads of the twin are counted")
+
+	// the request keeps the capacity of its own deployment
+	reason, err := scheduler.CheckAssignment(&Request{Capacity: Capacity{MRU: 8 * gb}}, 1, share)
+	assert.NoError(t, err)
+	assert.Equal(t, "", reason)
+	// but the node is over-committed if the request needs more than it deployed
+	reason, err = scheduler.CheckAssignment(&Request{Capacity: Capacity{MRU: 12 * gb}}, 1, share)
+	assert.NoError(t, err)
+	assert.Equal(t, "node 1 doesn't have enough capacity", reason)
+}
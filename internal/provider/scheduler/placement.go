@@ -0,0 +1,235 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+// maxSchedulingSteps bounds the nodes tried while searching for an assignment satisfying the placement constraints
+const maxSchedulingSteps = 500
+
+var errTooManySteps = fmt.Errorf("couldn't find an assignment satisfying the placement constraints after trying %d nodes", maxSchedulingSteps)
+
+// constraintError is returned when a constrained request can't be assigned, other assignments of the previous requests might fix it
+type constraintError struct {
+	err error
+}
+
+func (e *constraintError) Error() string {
+	return e.err.Error()
+}
+
+func (e *constraintError) Unwrap() error {
+	return e.err
+}
+
+// PlacementGroup constrains the placement of the requests belonging to it relative to each other
+type PlacementGroup struct {
+	Name              string
+	DistinctFarms     bool
+	DistinctCountries bool
+	DistinctCities    bool
+	// MaxPerNode is the maximum count of the group requests on a node, 0 for no limit
+	MaxPerNode uint32
+}
+
+// placement is a partial assignment of requests to nodes
+type placement struct {
+	groups     map[string]PlacementGroup
+	requests   map[string]*Request
+	assignment map[string]uint32
+	nodes      map[uint32]proxyTypes.Node
+	// listings are the grid proxy pages listed for each request, so backtracking doesn't list them again
+	listings map[string]*nodeListing
+	// constrained is true if the requests have placement constraints needing the details of the assigned nodes
+	constrained bool
+}
+
+func (p *placement) assign(r *Request, nodeID uint32, node proxyTypes.Node) {
+	p.assignment[r.Name] = nodeID
+	p.nodes[nodeID] = node
+}
+
+func (p *placement) unassign(r *Request) {
+	delete(p.assignment, r.Name)
+}
+
+// assignedNodes returns the nodes used by the assignment
+func (p *placement) assignedNodes() []uint32 {
+	nodes := []uint32{}
+	for _, node := range p.assignment {
+		if !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// violation returns the placement constraint violated by assigning the request to the node, empty if there's none
+func (p *placement) violation(r *Request, node proxyTypes.Node) string {
+	group, inGroup := p.groups[r.Group]
+	onNode := uint32(0)
+
+	for name, nodeID := range p.assignment {
+		other := p.nodes[nodeID]
+
+		if r.SameFarmAs == name && other.FarmID != node.FarmID {
+			return fmt.Sprintf("not on the farm of request %s", name)
+		}
+
+		assigned, ok := p.requests[name]
+		if !inGroup || !ok || assigned.Group != r.Group {
+			continue
+		}
+		if nodeID == uint32(node.NodeID) {
+			onNode++
+		}
+		if group.DistinctFarms && other.FarmID == node.FarmID {
+			return fmt.Sprintf("farm %d is used by request %s of placement group %s", node.FarmID, name, group.Name)
+		}
+		if group.DistinctCountries && other.Country == node.Country {
+			return fmt.Sprintf("country %s is used by request %s of placement group %s", node.Country, name, group.Name)
+		}
+		if group.DistinctCities && other.Country == node.Country && other.City == node.City {
+			return fmt.Sprintf("city %s is used by request %s of placement group %s", node.City, name, group.Name)
+		}
+	}
+
+	if inGroup && group.MaxPerNode != 0 && onNode >= group.MaxPerNode {
+		return fmt.Sprintf("node %d already has %d requests of placement group %s", node.NodeID, onNode, group.Name)
+	}
+	return ""
+}
+
+// constrained is true if the request placement depends on the other requests
+func (r *Request) constrained() bool {
+	return r.Distinct || r.Group != "" || r.SameFarmAs != ""
+}
+
+// orderRequests returns the unassigned requests, ordered so a request comes after the one it shares the farm with
+func (p *placement) orderRequests(reqs []Request) ([]Request, error) {
+	pending := make(map[string]bool)
+	for _, r := range reqs {
+		if _, ok := p.assignment[r.Name]; !ok {
+			pending[r.Name] = true
+		}
+	}
+
+	for _, r := range reqs {
+		if r.Group != "" {
+			if _, ok := p.groups[r.Group]; !ok {
+				return nil, fmt.Errorf("request %s references unknown placement group %s", r.Name, r.Group)
+			}
+		}
+		if r.SameFarmAs == "" {
+			continue
+		}
+		if _, ok := p.requests[r.SameFarmAs]; !ok {
+			if _, assigned := p.assignment[r.SameFarmAs]; !assigned {
+				return nil, fmt.Errorf("request %s references unknown request %s", r.Name, r.SameFarmAs)
+			}
+		}
+	}
+
+	ordered := make([]Request, 0, len(pending))
+	for len(ordered) < len(pending) {
+		progress := false
+		for _, r := range reqs {
+			if !pending[r.Name] || containsRequest(ordered, r.Name) {
+				continue
+			}
+			if r.SameFarmAs != "" && pending[r.SameFarmAs] && !containsRequest(ordered, r.SameFarmAs) {
+				continue
+			}
+			ordered = append(ordered, r)
+			progress = true
+		}
+		if !progress {
+			return nil, fmt.Errorf("same_farm_as references of the requests form a cycle")
+		}
+	}
+	return ordered, nil
+}
+
+func containsRequest(reqs []Request, name string) bool {
+	for _, r := range reqs {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// solve assigns the requests from idx on, trying other nodes for the constrained requests if the next ones can't be assigned
+func (s *Scheduler) solve(ctx context.Context, p *placement, reqs []Request, idx int, steps *int) error {
+	if idx == len(reqs) {
+		return nil
+	}
+
+	r := reqs[idx]
+	r.NodeExclude = append([]uint32{}, r.NodeExclude...)
+	if r.Distinct {
+		r.NodeExclude = append(r.NodeExclude, p.assignedNodes()...)
+	}
+
+	listing, ok := p.listings[r.Name]
+	if !ok {
+		listing = &nodeListing{}
+		p.listings[r.Name] = listing
+	}
+
+	var deepest error
+	for {
+		if *steps >= maxSchedulingSteps {
+			return errTooManySteps
+		}
+		*steps++
+
+		nodeID, err := s.find(ctx, &r, func(node *nodeInfo) bool {
+			return p.violation(&r, node.Node) == ""
+		}, listing)
+		if err != nil {
+			if deepest != nil {
+				return deepest
+			}
+			if r.constrained() {
+				return &constraintError{errors.Wrapf(err, "couldn't schedule request %s satisfying its placement constraints", r.Name)}
+			}
+			return errors.Wrapf(err, "couldn't schedule request %s", r.Name)
+		}
+
+		var node proxyTypes.Node
+		if p.constrained {
+			node, err = s.nodeDetails(nodeID)
+			if err != nil {
+				return errors.Wrapf(err, "couldn't get node %d of request %s", nodeID, r.Name)
+			}
+			// the farmer bot doesn't know about the placement constraints
+			if p.violation(&r, node) != "" {
+				r.NodeExclude = append(r.NodeExclude, nodeID)
+				continue
+			}
+		}
+
+		s.consume(nodeID, &r)
+		p.assign(&r, nodeID, node)
+		err = s.solve(ctx, p, reqs, idx+1, steps)
+		if err == nil {
+			return nil
+		}
+		s.release(nodeID, &r)
+		p.unassign(&r)
+
+		// only the constrained requests depend on the previous assignments
+		var constraintErr *constraintError
+		if !errors.As(err, &constraintErr) {
+			return err
+		}
+		deepest = err
+		r.NodeExclude = append(r.NodeExclude, nodeID)
+	}
+}
@@ -0,0 +1,193 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func placementProxy() *GridProxyClientMock {
+	proxy := &GridProxyClientMock{}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:         1,
+		FarmID:         1,
+		Country:        "Belgium",
+		City:           "Ghent",
+		Uptime:         30,
+		TotalResources: proxyTypes.Capacity{MRU: 16},
+	})
+	proxy.AddNode(2, proxyTypes.Node{
+		NodeID:         2,
+		FarmID:         1,
+		Country:        "Belgium",
+		City:           "Lochristi",
+		Uptime:         20,
+		TotalResources: proxyTypes.Capacity{MRU: 4},
+	})
+	proxy.AddNode(3, proxyTypes.Node{
+		NodeID:         3,
+		FarmID:         2,
+		Country:        "Egypt",
+		City:           "Cairo",
+		Uptime:         10,
+		TotalResources: proxyTypes.Capacity{MRU: 4},
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 2,
+	})
+	return proxy
+}
+
+func TestPlacementGroupDistinctFarms(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "ha", DistinctFarms: true})
+	requests := []Request{
+		{Name: "r1", Group: "ha", Strategy: UptimeStrategy},
+		{Name: "r2", Group: "ha", Strategy: UptimeStrategy},
+	}
+	assignment := map[string]uint32{}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"r1": 1, "r2": 3}, assignment)
+}
+
+func TestPlacementGroupBacktracking(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "ha", DistinctCountries: true})
+	requests := []Request{
+		// r1 prefers node 1, but only node 1 fits r2
+		{Name: "r1", Group: "ha", Strategy: UptimeStrategy},
+		{Name: "r2", Group: "ha", Capacity: Capacity{MRU: 8}},
+	}
+	assignment := map[string]uint32{}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"r1": 3, "r2": 1}, assignment)
+}
+
+func TestPlacementGroupMaxPerNode(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "pack", MaxPerNode: 2})
+	requests := []Request{
+		{Name: "r1", Group: "pack", Strategy: UptimeStrategy},
+		{Name: "r2", Group: "pack", Strategy: UptimeStrategy},
+		{Name: "r3", Group: "pack", Strategy: UptimeStrategy},
+	}
+	assignment := map[string]uint32{}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"r1": 1, "r2": 1, "r3": 2}, assignment)
+}
+
+func TestPlacementGroupUnsatisfiable(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "ha", DistinctFarms: true})
+	requests := []Request{
+		{Name: "r1", Group: "ha"},
+		{Name: "r2", Group: "ha"},
+		{Name: "r3", Group: "ha"},
+	}
+	err := scheduler.ProcessRequests(context.Background(), requests, map[string]uint32{})
+	assert.ErrorContains(t, err, "couldn't schedule request r3 satisfying its placement constraints")
+}
+
+func TestPlacementBacktrackingListsNodesOnce(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 2})
+	for i := 1; i <= 2*nodesPageSize+1; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, FarmID: 1 + i%2, Status: "up"})
+	}
+
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "ha", DistinctFarms: true})
+	requests := []Request{
+		{Name: "r1", Group: "ha"},
+		{Name: "r2", Group: "ha"},
+		{Name: "r3", Group: "ha"},
+	}
+	err := scheduler.ProcessRequests(context.Background(), requests, map[string]uint32{})
+	assert.Error(t, err)
+	// each request lists the 3 pages at most once, however many nodes the backtracking tries
+	assert.LessOrEqual(t, proxy.nodePages, 3*len(requests))
+}
+
+func TestPlacementKeepsPreviousAssignment(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "ha", DistinctCities: true})
+	requests := []Request{
+		{Name: "r1", Group: "ha"},
+		{Name: "r2", Group: "ha", Strategy: UptimeStrategy},
+	}
+	assignment := map[string]uint32{"r1": 1}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"r1": 1, "r2": 2}, assignment)
+}
+
+func TestSameFarmAs(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+	requests := []Request{
+		{Name: "r2", SameFarmAs: "r1", Capacity: Capacity{MRU: 2}, Strategy: UptimeStrategy},
+		{Name: "r1", Capacity: Capacity{MRU: 2}, NodeExclude: []uint32{1, 2}},
+	}
+	assignment := map[string]uint32{}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"r1": 3, "r2": 3}, assignment)
+}
+
+func TestPlacementInvalidReferences(t *testing.T) {
+	scheduler := NewScheduler(placementProxy(), 1, &RMBClientMock{})
+
+	err := scheduler.ProcessRequests(context.Background(), []Request{{Name: "r1", Group: "ha"}}, map[string]uint32{})
+	assert.ErrorContains(t, err, "unknown placement group ha")
+
+	err = scheduler.ProcessRequests(context.Background(), []Request{{Name: "r1", SameFarmAs: "r0"}}, map[string]uint32{})
+	assert.ErrorContains(t, err, "unknown request r0")
+
+	err = scheduler.ProcessRequests(context.Background(), []Request{
+		{Name: "r1", SameFarmAs: "r2"},
+		{Name: "r2", SameFarmAs: "r1"},
+	}, map[string]uint32{})
+	assert.ErrorContains(t, err, "cycle")
+}
+
+func TestPlacementBacktrackingAsksFarmerBotOnce(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 11})
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 4}})
+	proxy.AddNode(2, proxyTypes.Node{NodeID: 2, FarmID: 1, Status: "standby", TotalResources: proxyTypes.Capacity{CRU: 4}})
+	for i := 3; i <= 5; i++ {
+		proxy.AddFarm(proxyTypes.Farm{FarmID: i, TwinID: 10 + i})
+		proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, FarmID: i, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 4}})
+	}
+	bots := &RMBClientMock{proxy: proxy, farms: map[uint32]int{11: 1}, powerOnChecks: 1}
+
+	scheduler := NewScheduler(proxy, 1, bots)
+	scheduler.SetFarmerBotConfig(FarmerBotConfig{CallTimeout: time.Second, PowerOnTimeout: time.Second, PollInterval: time.Millisecond})
+	scheduler.AddPlacementGroup(PlacementGroup{Name: "ha", DistinctFarms: true})
+	requests := []Request{
+		{Name: "r1", Group: "ha"},
+		{Name: "r2", Group: "ha", FarmId: 1},
+		// no node fits r3, so all the assignments of r1 and r2 are tried
+		{Name: "r3", Group: "ha", Capacity: Capacity{CRU: 8}},
+	}
+	err := scheduler.ProcessRequests(context.Background(), requests, map[string]uint32{})
+	assert.Error(t, err)
+
+	findNode := 0
+	for _, job := range bots.jobs {
+		if job.Action == FarmerBotFindNodeAction {
+			findNode++
+		}
+	}
+	// the bot is asked for a node, another one excluding node 1 and another one excluding both nodes
+	assert.Equal(t, 3, findNode, "the farmer bot isn't asked again for the same job")
+}
@@ -0,0 +1,151 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"log"
+	"math"
+
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+const (
+	hoursPerMonth = 24 * 30
+	// chainPriceUnit is the number of units of a USD in the prices of the chain pricing policies
+	chainPriceUnit = 10_000_000
+)
+
+// PricingPolicy holds the prices of the grid resource units in USD per hour
+type PricingPolicy struct {
+	CU  float64
+	SU  float64
+	IPU float64
+	// CertifiedMarkup is the extra ratio paid for using certified nodes
+	CertifiedMarkup float64
+	// DedicatedDiscount is the discount ratio granted on dedicated nodes
+	DedicatedDiscount float64
+}
+
+// DefaultPricingPolicy is the default pricing policy of the grid,
+// it's used as a fallback when the pricing policy of a farm can't be read from the chain.
+var DefaultPricingPolicy = PricingPolicy{
+	CU:                0.01,
+	SU:                0.005,
+	IPU:               0.004,
+	CertifiedMarkup:   0.25,
+	DedicatedDiscount: 0.5,
+}
+
+// NewPricingPolicy converts a pricing policy of the chain,
+// the chain doesn't hold the certified markup so the default one is kept.
+func NewPricingPolicy(policy substrate.PricingPolicy) PricingPolicy {
+	return PricingPolicy{
+		CU:                float64(policy.CU.Value) / chainPriceUnit,
+		SU:                float64(policy.SU.Value) / chainPriceUnit,
+		IPU:               float64(policy.IPU.Value) / chainPriceUnit,
+		CertifiedMarkup:   DefaultPricingPolicy.CertifiedMarkup,
+		DedicatedDiscount: float64(policy.DedicatedNodesDiscount) / 100,
+	}
+}
+
+// computeUnits converts a capacity to compute units
+func computeUnits(c Capacity) float64 {
+	mru := float64(c.MRU) / float64(gridtypes.Gigabyte)
+	cru := float64(c.CRU)
+	return math.Min(
+		math.Min(math.Max(mru/4, cru/2), math.Max(mru/8, cru)),
+		math.Max(mru/2, cru/4),
+	)
+}
+
+// storageUnits converts a capacity to storage units
+func storageUnits(c Capacity) float64 {
+	hru := float64(c.HRU) / float64(gridtypes.Gigabyte)
+	sru := float64(c.SRU) / float64(gridtypes.Gigabyte)
+	return hru/1200 + sru/200
+}
+
+// MonthlyCost estimates the monthly cost in USD of a capacity with some public ips on a node
+func (p PricingPolicy) MonthlyCost(c Capacity, publicIPs uint32, certified, dedicated bool) float64 {
+	cost := computeUnits(c)*p.CU + storageUnits(c)*p.SU + float64(publicIPs)*p.IPU
+	if certified {
+		cost *= 1 + p.CertifiedMarkup
+	}
+	if dedicated {
+		cost *= 1 - p.DedicatedDiscount
+	}
+	return cost * hoursPerMonth
+}
+
+// requestCost estimates the monthly cost of a request if it's deployed on the node.
+// The rent contract of a node rented by the scheduler's twin already pays for its capacity, so only the public ips
+// of the request are paid on it, with the discount of the dedicated nodes. The other nodes aren't rented yet,
+// so the request pays for its capacity without the discount.
+func (p PricingPolicy) requestCost(r *Request, node *nodeInfo, rentedByMe bool) float64 {
+	certified := node.Node.CertificationType == "Certified"
+	if rentedByMe {
+		return p.MonthlyCost(Capacity{}, r.PublicIpsCount, certified, true)
+	}
+	return p.MonthlyCost(r.Capacity, r.PublicIpsCount, certified, false)
+}
+
+// requestCost estimates the monthly cost of a request on the node with the pricing policy of its farm
+func (n *Scheduler) requestCost(r *Request, node *nodeInfo) float64 {
+	rentedByMe := node.Node.RentedByTwinID != 0 && uint64(node.Node.RentedByTwinID) == n.twinID
+	return n.farmPricing(uint32(node.Node.FarmID)).requestCost(r, node, rentedByMe)
+}
+
+// farmPricing returns the pricing policy of a farm, the default one is used if it can't be read
+func (n *Scheduler) farmPricing(farmID uint32) PricingPolicy {
+	farm, err := n.getFarmInfo(farmID)
+	if err != nil {
+		log.Printf("couldn't get the pricing policy of farm %d, using the default one: %s", farmID, err)
+		return n.pricing
+	}
+	if policy, ok := n.policies[farm.pricingPolicyID]; ok {
+		return policy
+	}
+
+	policy := n.pricing
+	if n.readPolicy != nil {
+		if policy, err = n.readPolicy(farm.pricingPolicyID); err != nil {
+			log.Printf("couldn't get pricing policy %d of farm %d, using the default one: %s", farm.pricingPolicyID, farmID, err)
+			policy = n.pricing
+		}
+	}
+	n.policies[farm.pricingPolicyID] = policy
+	return policy
+}
+
+// withinBudget is true if the request's estimated cost on the node doesn't exceed its max monthly price
+func (n *Scheduler) withinBudget(r *Request, node *nodeInfo) bool {
+	return r.MaxMonthlyPrice == 0 || n.requestCost(r, node) <= r.MaxMonthlyPrice
+}
+
+// EstimateCost estimates the monthly cost in USD of a request on a node
+func (n *Scheduler) EstimateCost(r *Request, nodeID uint32) (float64, error) {
+	node, err := n.nodeDetails(nodeID)
+	if err != nil {
+		return 0, err
+	}
+	return n.requestCost(r, &nodeInfo{Node: node}), nil
+}
+
+// estimateCost records the estimated monthly cost of the request on its node
+func (n *Scheduler) estimateCost(nodeID uint32, r *Request) {
+	cost, err := n.EstimateCost(r, nodeID)
+	if err != nil {
+		log.Printf("couldn't estimate the cost of request %s: %s", r.Name, err)
+		return
+	}
+	n.costs[r.Name] = cost
+}
+
+// Costs returns the estimated monthly cost in USD of the requests assigned by the scheduler
+func (n *Scheduler) Costs() map[string]float64 {
+	res := make(map[string]float64, len(n.costs))
+	for name, cost := range n.costs {
+		res[name] = cost
+	}
+	return res
+}
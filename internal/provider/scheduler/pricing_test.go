@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+func TestSchedulerBudget(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 11, PublicIps: []proxyTypes.PublicIP{{IP: "a"}}})
+	capacity := proxyTypes.Capacity{CRU: 8, MRU: 32 * gridtypes.Gigabyte, SRU: 1000 * gridtypes.Gigabyte}
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, Status: "up", CertificationType: "Certified", TotalResources: capacity})
+	proxy.AddNode(2, proxyTypes.Node{NodeID: 2, FarmID: 1, Status: "up", CertificationType: "Diy", Dedicated: true, TotalResources: capacity})
+	proxy.AddNode(3, proxyTypes.Node{NodeID: 3, FarmID: 1, Status: "up", CertificationType: "Certified", RentedByTwinID: 1, TotalResources: capacity})
+
+	c := Capacity{CRU: 2, MRU: 4 * uint64(gridtypes.Gigabyte)}
+	regular := DefaultPricingPolicy.MonthlyCost(c, 0, false, false)
+	certified := DefaultPricingPolicy.MonthlyCost(c, 0, true, false)
+	dedicated := DefaultPricingPolicy.MonthlyCost(c, 0, false, true)
+	// the rent contract pays for the capacity of rented nodes, and the ips get the discount of the dedicated nodes
+	rentedIP := DefaultPricingPolicy.MonthlyCost(Capacity{}, 1, true, true)
+
+	cases := []struct {
+		name     string
+		request  Request
+		expected uint32
+		cost     float64
+	}{
+		{"only ips are paid on rented nodes", Request{Capacity: c, Certified: true, PublicIpsCount: 1, MaxMonthlyPrice: rentedIP}, 3, rentedIP},
+		{"no discount on nodes not rented yet", Request{Capacity: c, Dedicated: true, NodeExclude: []uint32{3}, MaxMonthlyPrice: regular}, 2, regular},
+		{"only the uncertified node fits", Request{Capacity: c, NodeExclude: []uint32{3}, MaxMonthlyPrice: regular}, 2, regular},
+		{"certified within budget", Request{Capacity: c, NodeExclude: []uint32{2, 3}, MaxMonthlyPrice: certified}, 1, certified},
+		{"too expensive", Request{Capacity: c, NodeExclude: []uint32{3}, MaxMonthlyPrice: dedicated}, 0, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+			tc.request.Name = "req"
+			nodeID, err := scheduler.Schedule(context.Background(), &tc.request)
+			if tc.expected == 0 {
+				assert.ErrorContains(t, err, "max_monthly_price")
+				assert.Empty(t, scheduler.Costs())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, nodeID)
+			assert.InDelta(t, tc.cost, scheduler.Costs()["req"], 1e-6)
+		})
+	}
+}
+
+func TestFarmPricingPolicies(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, PricingPolicyID: 1})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 2, PricingPolicyID: 2})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 3, PricingPolicyID: 3})
+	for i := 1; i <= 4; i++ {
+		farmID := (i + 1) / 2
+		proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, FarmID: farmID, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+	}
+	proxy.AddNode(5, proxyTypes.Node{NodeID: 5, FarmID: 3, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+
+	cheap := DefaultPricingPolicy
+	cheap.CU /= 2
+	calls := map[uint32]int{}
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+	scheduler.SetPricingPolicies(func(id uint32) (PricingPolicy, error) {
+		calls[id]++
+		switch id {
+		case 1:
+			return DefaultPricingPolicy, nil
+		case 2:
+			return cheap, nil
+		}
+		return PricingPolicy{}, fmt.Errorf("pricing policy %d not found", id)
+	})
+
+	c := Capacity{CRU: 2}
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", Capacity: c, Strategy: CheapestStrategy})
+	assert.NoError(t, err)
+	assert.Contains(t, []uint32{3, 4}, nodeID, "the nodes of farm 2 are the cheapest")
+	assert.InDelta(t, cheap.MonthlyCost(c, 0, false, false), scheduler.Costs()["req"], 1e-6)
+
+	cost, err := scheduler.EstimateCost(&Request{Capacity: c}, 5)
+	assert.NoError(t, err)
+	assert.InDelta(t, DefaultPricingPolicy.MonthlyCost(c, 0, false, false), cost, 1e-6, "the default policy is used if the farm's one can't be read")
+	assert.Equal(t, map[uint32]int{1: 1, 2: 1, 3: 1}, calls, "each policy is only read once")
+}
+
+func TestNewPricingPolicy(t *testing.T) {
+	policy := NewPricingPolicy(substrate.PricingPolicy{
+		CU:                     substrate.Policy{Value: 100000},
+		SU:                     substrate.Policy{Value: 50000},
+		IPU:                    substrate.Policy{Value: 40000},
+		DedicatedNodesDiscount: 50,
+	})
+	assert.InDelta(t, DefaultPricingPolicy.CU, policy.CU, 1e-9)
+	assert.InDelta(t, DefaultPricingPolicy.SU, policy.SU, 1e-9)
+	assert.InDelta(t, DefaultPricingPolicy.IPU, policy.IPU, 1e-9)
+	assert.Equal(t, DefaultPricingPolicy.CertifiedMarkup, policy.CertifiedMarkup)
+	assert.InDelta(t, DefaultPricingPolicy.DedicatedDiscount, policy.DedicatedDiscount, 1e-9)
+}
+
+func TestFarmerBotBudget(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 11})
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+	bots := &RMBClientMock{proxy: proxy, farms: map[uint32]int{11: 1}}
+
+	scheduler := NewScheduler(proxy, 1, bots)
+	_, err := scheduler.Schedule(context.Background(), &Request{Name: "req", FarmId: 1, Capacity: Capacity{CRU: 4}, MaxMonthlyPrice: 0.01})
+	assert.ErrorContains(t, err, "more than the max monthly price")
+
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", FarmId: 1, Capacity: Capacity{CRU: 4}, MaxMonthlyPrice: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), nodeID)
+	assert.Contains(t, scheduler.Costs(), "req")
+}
@@ -18,13 +18,33 @@ type Request struct {
 	PublicConfig   bool
 	PublicIpsCount uint32
 	Certified      bool
-	Dedicated      bool
-	NodeExclude    []uint32
-	Distinct       bool
+	// FarmCertification is the certification type of the node's farm, e.g. Gold or NotCertified
+	FarmCertification string
+	Dedicated         bool
+	NodeExclude       []uint32
+	Distinct          bool
+	// Strategy ranks the candidate nodes, one of Strategies
+	Strategy string
+	// Group is the name of the placement group of the request
+	Group string
+	// SameFarmAs is the name of the request this request must share the farm with
+	SameFarmAs string
+	Country    string
+	City       string
+	IPv4       bool
+	IPv6       bool
+	// MinZosVersion is the minimum zos version of the node, e.g. 3.6.0
+	MinZosVersion string
+	RentedByMe    bool
+	// PreferRentedByMe ranks the nodes rented by the scheduler's twin first
+	PreferRentedByMe bool
+	FarmExclude      []uint32
+	// MaxMonthlyPrice is the maximum estimated monthly cost of the request in USD, 0 for no limit
+	MaxMonthlyPrice float64
 }
 
 func (r *Request) constructFilter(twinID uint64) (f proxyTypes.NodeFilter) {
-	// this filter only lacks certification type, which is validated after.
+	// this filter lacks the certification types, which are validated after.
 	// grid proxy should support filtering a node by certification type.
 	f.Status = &statusUP
 	f.AvailableFor = &twinID
@@ -40,6 +60,11 @@ func (r *Request) constructFilter(twinID uint64) (f proxyTypes.NodeFilter) {
 	if r.Capacity.MRU != 0 {
 		f.FreeMRU = &r.Capacity.MRU
 	}
+	// grid proxy can't filter on free CPUs, so only nodes with enough CPUs in total are listed.
+	// the free CPUs are validated after.
+	if r.Capacity.CRU != 0 {
+		f.TotalCRU = &r.Capacity.CRU
+	}
 	if r.PublicConfig {
 		f.Domain = &trueVal
 	}
@@ -50,5 +75,20 @@ func (r *Request) constructFilter(twinID uint64) (f proxyTypes.NodeFilter) {
 	if r.Dedicated {
 		f.Rentable = &trueVal
 	}
+	if r.Country != "" {
+		f.Country = &r.Country
+	}
+	if r.City != "" {
+		f.City = &r.City
+	}
+	if r.IPv4 {
+		f.IPv4 = &trueVal
+	}
+	if r.IPv6 {
+		f.IPv6 = &trueVal
+	}
+	if r.RentedByMe {
+		f.RentedBy = &twinID
+	}
 	return f
 }
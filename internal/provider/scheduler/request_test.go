@@ -32,7 +32,7 @@ func TestFulfilsSuccess(t *testing.T) {
 		FarmId:         1,
 		PublicIpsCount: 1,
 		PublicConfig:   false,
-	}, farm), true, "fullfil-success")
+	}, farm, 1), true, "fullfil-success")
 }
 
 func TestFulfilsFail(t *testing.T) {
@@ -60,30 +60,88 @@ func TestFulfilsFail(t *testing.T) {
 	farmInfo := farmInfo{
 		freeIPs: 1,
 	}
-	assert.Equal(t, nodeInfo.fulfils(&req, farmInfo), true, "this request should be successful")
+	assert.Equal(t, nodeInfo.fulfils(&req, farmInfo, 1), true, "this request should be successful")
 
 	violations := map[string]func(r *Request){
+		"cru":              func(r *Request) { r.Capacity.CRU = 4 },
 		"mru":              func(r *Request) { r.Capacity.MRU = 4 },
 		"sru":              func(r *Request) { r.Capacity.SRU = 9 },
 		"hru":              func(r *Request) { r.Capacity.HRU = 4 },
 		"farm_id":          func(r *Request) { r.FarmId = 2 },
 		"public_ips_count": func(r *Request) { r.PublicIpsCount = 3 },
 		"public_config":    func(r *Request) { r.PublicConfig = true },
+		"country":          func(r *Request) { r.Country = "Egypt" },
+		"city":             func(r *Request) { r.City = "Cairo" },
+		"ipv4":             func(r *Request) { r.IPv4 = true },
+		"ipv6":             func(r *Request) { r.IPv6 = true },
+		"rented_by_me":     func(r *Request) { r.RentedByMe = true },
+		"farm_exclude":     func(r *Request) { r.FarmExclude = []uint32{1} },
+		"min_zos_version":  func(r *Request) { r.MinZosVersion = "3.0.0" },
 	}
 	for key, fn := range violations {
 		cp := req
 		fn(&cp)
 
-		assert.Equal(t, nodeInfo.fulfils(&cp, farmInfo), false, fmt.Sprintf("fullfil-fail-%s", key))
+		assert.Equal(t, nodeInfo.fulfils(&cp, farmInfo, 1), false, fmt.Sprintf("fullfil-fail-%s", key))
 	}
 }
 
+func TestFulfilsLocation(t *testing.T) {
+	cap := freeCapacity(&node)
+	nodeInfo := nodeInfo{
+		FreeCapacity: &cap,
+		Node: types.Node{
+			FarmID:         1,
+			Country:        "Belgium",
+			City:           "Ghent",
+			RentedByTwinID: 7,
+			PublicConfig: types.PublicConfig{
+				Ipv4: "1.2.3.4/24",
+				Ipv6: "2a02::1/64",
+			},
+		},
+		ZosVersion: "v3.6.1",
+	}
+	req := Request{
+		Country:       "belgium",
+		City:          "Ghent",
+		IPv4:          true,
+		IPv6:          true,
+		RentedByMe:    true,
+		FarmExclude:   []uint32{2},
+		MinZosVersion: "3.6",
+	}
+	assert.True(t, nodeInfo.fulfils(&req, farmInfo{}, 7), "fulfils-location")
+	assert.False(t, nodeInfo.fulfils(&req, farmInfo{}, 8), "fulfils-rented-by-other")
+
+	req.MinZosVersion = "3.7.0"
+	assert.False(t, nodeInfo.fulfils(&req, farmInfo{}, 7), "fulfils-old-zos")
+}
+
+func TestConstructFilterLocation(t *testing.T) {
+	r := Request{
+		Country:    "Belgium",
+		City:       "Ghent",
+		IPv4:       true,
+		IPv6:       true,
+		RentedByMe: true,
+	}
+
+	con := r.constructFilter(1)
+	assert.Equal(t, *con.Country, "Belgium", "construct-filter-country")
+	assert.Equal(t, *con.City, "Ghent", "construct-filter-city")
+	assert.Equal(t, *con.IPv4, true, "construct-filter-ipv4")
+	assert.Equal(t, *con.IPv6, true, "construct-filter-ipv6")
+	assert.Equal(t, *con.RentedBy, uint64(1), "construct-filter-rented-by")
+}
+
 func TestConstructFilter(t *testing.T) {
 	r := Request{
 		Capacity: Capacity{
 			MRU: 1,
 			SRU: 2,
 			HRU: 3,
+			CRU: 4,
 		},
 		Name:           "a",
 		FarmId:         1,
@@ -97,6 +155,7 @@ func TestConstructFilter(t *testing.T) {
 	assert.Equal(t, *con.FreeMRU, uint64(1), "construct-filter-mru")
 	assert.Equal(t, *con.FreeSRU, uint64(2), "construct-filter-sru")
 	assert.Equal(t, *con.FreeHRU, uint64(3), "construct-filter-hru")
+	assert.Equal(t, *con.TotalCRU, uint64(4), "construct-filter-cru")
 	assert.Empty(t, con.Country, "construct-filter-country")
 	assert.Empty(t, con.City, "construct-filter-city")
 	assert.Equal(t, con.FarmIDs, []uint64{uint64(r.FarmId)}, "construct-filter-farm-ids")
@@ -4,9 +4,15 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"log"
 	"math/rand"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	client "github.com/threefoldtech/tfgrid-sdk-go/grid-client/node"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
 	proxy "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/client"
 	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 	"github.com/threefoldtech/tfgrid-sdk-go/rmb-sdk-go"
@@ -19,38 +25,138 @@ type Scheduler struct {
 	twinID          uint64
 	gridProxyClient proxy.Client
 	rmbClient       rmb.Client
+	nodeClients     client.NodeClientGetter
+	sub             subi.SubstrateExt
+	rand            *rand.Rand
+	pricing         PricingPolicy
+	readPolicy      func(id uint32) (PricingPolicy, error)
+	policies        map[uint32]PricingPolicy
+	groups          map[string]PlacementGroup
+	details         map[uint32]proxyTypes.Node
+	farmerBots      map[uint32]bool
+	farmerBotNodes  map[string]farmerBotFound
+	certifiedFarms  map[string][]uint64
+	explanations    map[string]*Explanation
+	farmerBot       FarmerBotConfig
+	costs           map[string]float64
 }
 
+const (
+	nodesPageSize = 50
+	farmsPageSize = 100
+)
+
 // nodeInfo related to scheduling
 type nodeInfo struct {
 	FreeCapacity *Capacity
 	Node         proxyTypes.Node
+	// ZosVersion is only fetched from the node when a request needs it
+	ZosVersion string
+	// ZosVersionLoaded is true once the node was asked for its zos version, even if it didn't answer
+	ZosVersionLoaded bool
 }
 
 type farmInfo struct {
 	freeIPs           uint64
 	certificationType string
 	farmerTwinID      uint32
+	pricingPolicyID   uint32
+}
+
+// consumePublicIPs reserves public ips of the node's farm, so the next requests can't over-commit it
+func (n *Scheduler) consumePublicIPs(nodeID uint32, ips uint32) {
+	n.updatePublicIPs(nodeID, func(farm *farmInfo) {
+		if uint64(ips) > farm.freeIPs {
+			farm.freeIPs = 0
+			return
+		}
+		farm.freeIPs -= uint64(ips)
+	})
+}
+
+// releasePublicIPs gives back the public ips reserved on the node's farm
+func (n *Scheduler) releasePublicIPs(nodeID uint32, ips uint32) {
+	n.updatePublicIPs(nodeID, func(farm *farmInfo) {
+		farm.freeIPs += uint64(ips)
+	})
+}
+
+func (n *Scheduler) updatePublicIPs(nodeID uint32, update func(*farmInfo)) {
+	node, err := n.nodeDetails(nodeID)
+	if err != nil {
+		log.Printf("couldn't update the public ips of the farm of node %d: %s", nodeID, err)
+		return
+	}
+	farmID := uint32(node.FarmID)
+	farm, err := n.getFarmInfo(farmID)
+	if err != nil {
+		log.Printf("couldn't update the public ips of farm %d: %s", farmID, err)
+		return
+	}
+	update(&farm)
+	n.farms[farmID] = farm
 }
 
-func (s *Scheduler) consumePublicIPs(farmID uint32, IPs uint32) {
-	farm := s.farms[farmID]
-	farm.freeIPs -= uint64(IPs)
+func (node *nodeInfo) fulfils(r *Request, farm farmInfo, twinID uint64) bool {
+	return node.rejection(r, farm, twinID) == ""
 }
 
-func (node *nodeInfo) fulfils(r *Request, farm farmInfo) bool {
-	if r.Capacity.MRU > node.FreeCapacity.MRU ||
-		r.Capacity.HRU > node.FreeCapacity.HRU ||
-		r.Capacity.SRU > node.FreeCapacity.SRU ||
-		(r.FarmId != 0 && node.Node.FarmID != int(r.FarmId)) ||
-		(r.PublicConfig && node.Node.PublicConfig.Domain == "") ||
-		(r.PublicIpsCount > uint32(farm.freeIPs)) ||
-		(r.Dedicated && !node.Node.Dedicated) ||
-		(r.Certified && node.Node.CertificationType != "Certified") ||
-		contains(r.NodeExclude, uint32(node.Node.NodeID)) {
-		return false
+// rejection returns the name of the first request field the node doesn't fulfil, empty if it fulfils them all
+func (node *nodeInfo) rejection(r *Request, farm farmInfo, twinID uint64) string {
+	if rejection := node.localRejection(r, twinID); rejection != "" {
+		return rejection
 	}
-	return true
+	return farmRejection(r, farm)
+}
+
+// localRejection is the rejection of the node only checking the fields known without its farm
+func (node *nodeInfo) localRejection(r *Request, twinID uint64) string {
+	switch {
+	case r.Capacity.CRU > node.FreeCapacity.CRU:
+		return "cru"
+	case r.Capacity.MRU > node.FreeCapacity.MRU:
+		return "mru"
+	case r.Capacity.HRU > node.FreeCapacity.HRU:
+		return "hru"
+	case r.Capacity.SRU > node.FreeCapacity.SRU:
+		return "sru"
+	case r.FarmId != 0 && node.Node.FarmID != int(r.FarmId):
+		return "farm_id"
+	case r.PublicConfig && node.Node.PublicConfig.Domain == "":
+		return "public_config"
+	case r.Dedicated && !node.Node.Dedicated:
+		return "dedicated"
+	case r.Certified && node.Node.CertificationType != "Certified":
+		return "certified"
+	case contains(r.NodeExclude, uint32(node.Node.NodeID)):
+		return "node_exclude"
+	case contains(r.FarmExclude, uint32(node.Node.FarmID)):
+		return "farm_exclude"
+	case r.Country != "" && !strings.EqualFold(node.Node.Country, r.Country):
+		return "country"
+	case r.City != "" && !strings.EqualFold(node.Node.City, r.City):
+		return "city"
+	case r.IPv4 && node.Node.PublicConfig.Ipv4 == "":
+		return "ipv4"
+	case r.IPv6 && node.Node.PublicConfig.Ipv6 == "":
+		return "ipv6"
+	case r.RentedByMe && uint64(node.Node.RentedByTwinID) != twinID:
+		return "rented_by_me"
+	case r.MinZosVersion != "" && compareVersions(node.ZosVersion, r.MinZosVersion) < 0:
+		return "min_zos_version"
+	}
+	return ""
+}
+
+// farmRejection is the rejection of the node for the fields of its farm
+func farmRejection(r *Request, farm farmInfo) string {
+	switch {
+	case r.PublicIpsCount > uint32(farm.freeIPs):
+		return "public_ips_count"
+	case r.FarmCertification != "" && !strings.EqualFold(farm.certificationType, r.FarmCertification):
+		return "farm_certification"
+	}
+	return ""
 }
 
 // NewScheduler generates a new scheduler
@@ -59,12 +165,44 @@ func NewScheduler(gridProxyClient proxy.Client, twinID uint64, rmbClient rmb.Cli
 		nodes:           map[uint32]nodeInfo{},
 		gridProxyClient: gridProxyClient,
 
-		twinID:    twinID,
-		farms:     make(map[uint32]farmInfo),
-		rmbClient: rmbClient,
+		twinID:         twinID,
+		farms:          make(map[uint32]farmInfo),
+		rmbClient:      rmbClient,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		pricing:        DefaultPricingPolicy,
+		policies:       make(map[uint32]PricingPolicy),
+		groups:         make(map[string]PlacementGroup),
+		details:        make(map[uint32]proxyTypes.Node),
+		farmerBots:     make(map[uint32]bool),
+		farmerBotNodes: make(map[string]farmerBotFound),
+		certifiedFarms: make(map[string][]uint64),
+		explanations:   make(map[string]*Explanation),
+		farmerBot:      DefaultFarmerBotConfig,
+		costs:          make(map[string]float64),
 	}
 }
 
+// AddPlacementGroup adds a placement group the requests can belong to
+func (n *Scheduler) AddPlacementGroup(group PlacementGroup) {
+	n.groups[group.Name] = group
+}
+
+// SetPricingPolicy sets the default pricing policy, used to estimate the costs of the requests on the farms
+// whose pricing policy can't be read
+func (n *Scheduler) SetPricingPolicy(policy PricingPolicy) {
+	n.pricing = policy
+}
+
+// SetPricingPolicies sets the getter of the pricing policies of the farms by id, each policy is only read once
+func (n *Scheduler) SetPricingPolicies(get func(id uint32) (PricingPolicy, error)) {
+	n.readPolicy = get
+}
+
+// SetSeed makes the random choices of the scheduler deterministic, so the same requests on the same grid give the same plan
+func (n *Scheduler) SetSeed(seed int64) {
+	n.rand = rand.New(rand.NewSource(seed))
+}
+
 func (n *Scheduler) getFarmInfo(farmID uint32) (farmInfo, error) {
 	if f, ok := n.farms[farmID]; ok {
 		return f, nil
@@ -87,6 +225,7 @@ func (n *Scheduler) getFarmInfo(farmID uint32) (farmInfo, error) {
 		freeIPs:           getPublicIPsCount(farm[0].PublicIps),
 		certificationType: farm[0].CertificationType,
 		farmerTwinID:      uint32(farm[0].TwinID),
+		pricingPolicyID:   uint32(farm[0].PricingPolicyID),
 	}
 	return n.farms[farmID], nil
 }
@@ -101,26 +240,59 @@ func getPublicIPsCount(publicIPs []proxyTypes.PublicIP) uint64 {
 	return uint64(freeIPs)
 }
 
-func (n *Scheduler) getNode(r *Request) uint32 {
-	nodes := make([]uint32, 0, len(n.nodes))
+// getNode returns the best ranked known node fulfilling the request and accepted by accept, 0 if there's none
+func (n *Scheduler) getNode(r *Request, accept func(*nodeInfo) bool) uint32 {
+	nodes := make([]*nodeInfo, 0, len(n.nodes))
 	for node := range n.nodes {
-		nodes = append(nodes, node)
+		nodeInfo := n.nodes[node]
+		nodes = append(nodes, &nodeInfo)
 	}
-	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
-	for _, node := range nodes {
-		farm, err := n.getFarmInfo(r.FarmId)
+	// start from a stable order so the ranking only depends on the seed
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node.NodeID < nodes[j].Node.NodeID })
+	n.strategy(r.Strategy).rank(r, nodes)
+	if r.PreferRentedByMe {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return n.rentedByMe(nodes[i]) && !n.rentedByMe(nodes[j])
+		})
+	}
+
+	explanation := newExplanation()
+	n.explanations[r.Name] = explanation
+	for _, nodeInfo := range nodes {
+		explanation.Considered++
+		// the farm is only fetched for the nodes fulfilling the rest of the request
+		if rejection := nodeInfo.localRejection(r, n.twinID); rejection != "" {
+			explanation.Rejections[rejection]++
+			continue
+		}
+		farm, err := n.getFarmInfo(uint32(nodeInfo.Node.FarmID))
 		if err != nil {
+			log.Printf("couldn't get farm %d of node %d: %s", nodeInfo.Node.FarmID, nodeInfo.Node.NodeID, err)
+			explanation.Rejections["farm"]++
 			continue
 		}
-		nodeInfo := n.nodes[node]
-		// TODO: later add free ips check when specifying the number of ips is supported
-		if nodeInfo.fulfils(r, farm) {
-			return node
+		if rejection := farmRejection(r, farm); rejection != "" {
+			explanation.Rejections[rejection]++
+			continue
+		}
+		if !n.withinBudget(r, nodeInfo) {
+			explanation.Rejections["max_monthly_price"]++
+			continue
 		}
+		if accept != nil && !accept(nodeInfo) {
+			explanation.Rejections["placement"]++
+			continue
+		}
+		explanation.Node = uint32(nodeInfo.Node.NodeID)
+		return explanation.Node
 	}
 	return 0
 }
 
+func (n *Scheduler) rentedByMe(node *nodeInfo) bool {
+	return uint64(node.Node.RentedByTwinID) == n.twinID
+}
+
 func (n *Scheduler) addNodes(nodes []proxyTypes.Node) {
 	for _, node := range nodes {
 		if _, ok := n.nodes[uint32(node.NodeID)]; !ok {
@@ -135,68 +307,217 @@ func (n *Scheduler) addNodes(nodes []proxyTypes.Node) {
 
 // Schedule makes sure there's at least one node that satisfies the given request
 func (n *Scheduler) Schedule(ctx context.Context, r *Request) (uint32, error) {
+	node, err := n.find(ctx, r, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	n.consume(node, r)
+	return node, nil
+}
+
+// find returns a node satisfying the request without consuming its capacity,
+// accept filters the candidate nodes further but the farmer bot doesn't apply it.
+// listing keeps the pages of the grid proxy already listed for the request between calls, nil to list from the first page.
+func (n *Scheduler) find(ctx context.Context, r *Request, accept func(*nodeInfo) bool, listing *nodeListing) (uint32, error) {
 	if r.FarmId != 0 {
 		if n.hasFarmerBot(ctx, r.FarmId) {
-			return n.farmerBotSchedule(ctx, r)
+			nodeID, err := n.farmerBotSchedule(ctx, r)
+			var rejection farmerBotRejection
+			if !errors.As(err, &rejection) {
+				return nodeID, err
+			}
+			log.Printf("%s, looking for a node through the grid proxy", rejection)
 		}
 	}
-	return n.gridProxySchedule(r)
+	if listing == nil {
+		listing = &nodeListing{}
+	}
+	return n.gridProxySchedule(ctx, r, accept, listing)
 }
 
-func (n *Scheduler) gridProxySchedule(r *Request) (uint32, error) {
+func (n *Scheduler) consume(node uint32, r *Request) {
+	if info, ok := n.nodes[node]; ok {
+		info.FreeCapacity.consume(r)
+	}
+	if r.PublicIpsCount != 0 {
+		n.consumePublicIPs(node, r.PublicIpsCount)
+	}
+	n.estimateCost(node, r)
+}
+
+// release gives back the capacity consumed by a request
+func (n *Scheduler) release(node uint32, r *Request) {
+	if info, ok := n.nodes[node]; ok {
+		info.FreeCapacity.release(r)
+	}
+	if r.PublicIpsCount != 0 {
+		n.releasePublicIPs(node, r.PublicIpsCount)
+	}
+	delete(n.costs, r.Name)
+}
+
+// nodeDetails returns the grid proxy information of a node, even if it's not eligible for scheduling
+func (n *Scheduler) nodeDetails(nodeID uint32) (proxyTypes.Node, error) {
+	if info, ok := n.nodes[nodeID]; ok {
+		return info.Node, nil
+	}
+	if node, ok := n.details[nodeID]; ok {
+		return node, nil
+	}
+
+	res, err := n.gridProxyClient.Node(nodeID)
+	if err != nil {
+		return proxyTypes.Node{}, errors.Wrapf(err, "couldn't get node %d from the grid proxy", nodeID)
+	}
+	node := proxyTypes.Node{
+		ID:                res.ID,
+		NodeID:            res.NodeID,
+		FarmID:            res.FarmID,
+		TwinID:            res.TwinID,
+		Country:           res.Country,
+		City:              res.City,
+		Uptime:            res.Uptime,
+		TotalResources:    res.Capacity.Total,
+		UsedResources:     res.Capacity.Used,
+		PublicConfig:      res.PublicConfig,
+		Status:            res.Status,
+		CertificationType: res.CertificationType,
+		Dedicated:         res.Dedicated,
+		RentContractID:    res.RentContractID,
+		RentedByTwinID:    res.RentedByTwinID,
+	}
+	n.details[nodeID] = node
+	return node, nil
+}
+
+// nodeListing is the progress of a request through the node pages of the grid proxy.
+// The listed nodes are kept by the scheduler, so a request looking for another node only lists the pages it didn't list yet.
+type nodeListing struct {
+	// pages is the count of pages already listed
+	pages uint64
+	// done is true once the last page is listed
+	done bool
+}
+
+func (n *Scheduler) gridProxySchedule(ctx context.Context, r *Request, accept func(*nodeInfo) bool, listing *nodeListing) (uint32, error) {
 	f := r.constructFilter(n.twinID)
-	l := proxyTypes.Limit{
-		Size:     10,
-		Page:     1,
-		RetCount: false,
+	if r.FarmCertification != "" && r.FarmId == 0 {
+		farms, err := n.farmsByCertification(r.FarmCertification)
+		if err != nil {
+			return 0, err
+		}
+		if len(farms) == 0 {
+			return 0, fmt.Errorf("couldn't find a farm with certification %s", r.FarmCertification)
+		}
+		f.FarmIDs = farms
 	}
 
-	node := n.getNode(r)
-	for node == 0 {
-		nodes, _, err := n.gridProxyClient.Nodes(f, l)
+	n.loadZosVersions(ctx, r)
+	if node := n.getNode(r, accept); node != 0 {
+		return node, nil
+	}
+
+	// the certification of the nodes can't be filtered by the grid proxy,
+	// so all the pages are scanned until the last one, which is shorter than the page size.
+	for !listing.done {
+		nodes, _, err := n.gridProxyClient.Nodes(f, proxyTypes.Limit{
+			Size:     nodesPageSize,
+			Page:     listing.pages + 1,
+			RetCount: false,
+		})
 		if err != nil {
 			return 0, errors.Wrap(err, "couldn't list nodes from the grid proxy")
 		}
-		if len(nodes) == 0 {
-			return 0, errors.New("couldn't find a node satisfying the given requirements")
-		}
+		listing.pages++
+		listing.done = len(nodes) < nodesPageSize
 		n.addNodes(nodes)
-		node = n.getNode(r)
-		if l.Page == 1 && l.Size == 10 {
-			l.Page = 2
-		} else {
-			l.Size *= 2
+		n.loadZosVersions(ctx, r)
+		if node := n.getNode(r, accept); node != 0 {
+			return node, nil
 		}
 	}
-	n.nodes[node].FreeCapacity.consume(r)
-	n.consumePublicIPs(r.FarmId, r.PublicIpsCount)
-	return node, nil
+	return 0, fmt.Errorf("couldn't find a node satisfying the given requirements, %s", n.explanations[r.Name])
 }
 
-func (s *Scheduler) ProcessRequests(ctx context.Context, reqs []Request, assignment map[string]uint32) error {
-	assignedNodes := []uint32{}
-	for _, node := range assignment {
-		if !contains(assignedNodes, node) {
-			assignedNodes = append(assignedNodes, node)
+// farmsByCertification lists the ids of the farms with the given certification type
+func (n *Scheduler) farmsByCertification(certification string) ([]uint64, error) {
+	if farms, ok := n.certifiedFarms[certification]; ok {
+		return farms, nil
+	}
+
+	farms := []uint64{}
+	filter := proxyTypes.FarmFilter{
+		CertificationType: &certification,
+	}
+	for page := uint64(1); ; page++ {
+		res, _, err := n.gridProxyClient.Farms(filter, proxyTypes.Limit{
+			Size: farmsPageSize,
+			Page: page,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't list farms from the grid proxy")
+		}
+		for _, farm := range res {
+			farms = append(farms, uint64(farm.FarmID))
+		}
+		if len(res) < farmsPageSize {
+			break
 		}
 	}
+	n.certifiedFarms[certification] = farms
+	return farms, nil
+}
 
-	for _, r := range reqs {
-		if r.Distinct {
-			r.NodeExclude = append(r.NodeExclude, assignedNodes...)
+// ProcessRequests assigns the requests missing from the assignment to nodes,
+// so that all the requests together satisfy their placement constraints.
+func (s *Scheduler) ProcessRequests(ctx context.Context, reqs []Request, assignment map[string]uint32) error {
+	p := placement{
+		groups:      s.groups,
+		requests:    make(map[string]*Request),
+		assignment:  make(map[string]uint32),
+		nodes:       make(map[uint32]proxyTypes.Node),
+		listings:    make(map[string]*nodeListing),
+		constrained: len(s.groups) != 0 || hasSameFarmAs(reqs),
+	}
+	for idx := range reqs {
+		p.requests[reqs[idx].Name] = &reqs[idx]
+	}
+	for name, nodeID := range assignment {
+		p.assignment[name] = nodeID
+		if !p.constrained {
+			continue
 		}
-		node, err := s.Schedule(ctx, &r)
+		node, err := s.nodeDetails(nodeID)
 		if err != nil {
-			return errors.Wrapf(err, "couldn't schedule request %s", r.Name)
-		}
-		assignment[r.Name] = node
-		if !contains(assignedNodes, node) {
-			assignedNodes = append(assignedNodes, node)
+			return errors.Wrapf(err, "couldn't get node %d of request %s", nodeID, name)
 		}
+		p.nodes[nodeID] = node
+	}
+
+	pending, err := p.orderRequests(reqs)
+	if err != nil {
+		return err
+	}
+
+	steps := 0
+	if err := s.solve(ctx, &p, pending, 0, &steps); err != nil {
+		return err
+	}
+	for name, node := range p.assignment {
+		assignment[name] = node
 	}
 	return nil
 }
 
+func hasSameFarmAs(reqs []Request) bool {
+	for _, r := range reqs {
+		if r.SameFarmAs != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func contains[T comparable](elements []T, element T) bool {
 	for _, e := range elements {
 		if element == e {
@@ -2,39 +2,111 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	client "github.com/threefoldtech/tfgrid-sdk-go/grid-client/node"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
 	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/tfgrid-sdk-go/rmb-sdk-go"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+const (
+	zosVersionCmd    = "zos.system.version"
+	deploymentGetCmd = "zos.deployment.get"
 )
 
 type GridProxyClientMock struct {
 	farms []proxyTypes.Farm
 	nodes []proxyTypes.Node
+	// poweringOn maps the nodes to the count of status checks before they are up
+	poweringOn map[uint32]int
+	// nodePages counts the pages of nodes listed
+	nodePages int
+	// farmQueries counts the farm listings
+	farmQueries int
+	contracts   []proxyTypes.Contract
 }
 
 type RMBClientMock struct {
 	nodeID       uint32
 	hasFarmerBot bool
+	// zosVersions maps the node twins to their zos versions
+	zosVersions map[uint32]string
+	// zosVersionCalls are the twins asked for their zos version, the nodes are asked concurrently
+	zosVersionCalls []uint32
+	// zosVersionDelay is how long the nodes take to answer, maxZosVersionCalls is the peak of concurrent calls
+	zosVersionDelay    time.Duration
+	zosVersionInFlight int
+	maxZosVersionCalls int
+	mu                 sync.Mutex
+	// nodeIDResult overrides the node id returned by the farmer bot
+	nodeIDResult interface{}
+	// jobs are the farmer bot jobs received
+	jobs []FarmerBotAction
+	// farms maps the farmer twins running a bot to their farms, the bots then find the nodes of proxy
+	farms map[uint32]int
+	proxy *GridProxyClientMock
+	// powerOnChecks is the count of status checks before a node powered on by a bot is up
+	powerOnChecks int
+	// deployments maps the contract ids to the deployments returned by the nodes
+	deployments map[uint64]gridtypes.Deployment
 }
 
 func (r *RMBClientMock) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	if fn == zosVersionCmd {
+		r.mu.Lock()
+		r.zosVersionInFlight++
+		if r.zosVersionInFlight > r.maxZosVersionCalls {
+			r.maxZosVersionCalls = r.zosVersionInFlight
+		}
+		r.mu.Unlock()
+		time.Sleep(r.zosVersionDelay)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.zosVersionInFlight--
+		r.zosVersionCalls = append(r.zosVersionCalls, twin)
+		version, ok := r.zosVersions[twin]
+		if !ok {
+			return fmt.Errorf("twin %d is not a node", twin)
+		}
+		return json.Unmarshal([]byte(fmt.Sprintf(`{"zos": %q}`, version)), result)
+	}
+	if fn == deploymentGetCmd {
+		return r.getDeployment(data, result)
+	}
+	if r.farms != nil {
+		return r.callFarmerBot(twin, fn, data, result)
+	}
 	d := data.(FarmerBotAction)
+	r.jobs = append(r.jobs, d)
+	output := result.(*FarmerBotAction)
 	switch d.Action {
 	case FarmerBotVersionAction:
 		if r.hasFarmerBot {
+			output.Result.Params = []Params{{Key: farmerBotVersionKey, Value: "0.1.0"}}
 			return nil
 		}
 		return errors.New("this farm does not have a farmer bot")
 	case FarmerBotFindNodeAction:
+		if r.nodeIDResult != nil {
+			output.Result.Params = []Params{{Key: farmerBotNodeIDKey, Value: r.nodeIDResult}}
+			return nil
+		}
 		if r.nodeID == 0 {
-			d.Error = "could not find node"
+			output.Error = "could not find node"
 			return nil
 		}
-		output := result.(*FarmerBotAction)
 
 		output.Result.Params = append(output.Args.Params, Params{Key: "nodeid", Value: strconv.FormatUint(uint64(r.nodeID), 10)})
 		return nil
@@ -43,48 +115,246 @@ func (r *RMBClientMock) Call(ctx context.Context, twin uint32, fn string, data i
 	}
 }
 
+// getDeployment answers a node's deployment get call
+func (r *RMBClientMock) getDeployment(data interface{}, result interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var args struct {
+		ContractID uint64 `json:"contract_id"`
+	}
+	if err := json.Unmarshal(content, &args); err != nil {
+		return err
+	}
+	dl, ok := r.deployments[args.ContractID]
+	if !ok {
+		return fmt.Errorf("contract %d has no deployment", args.ContractID)
+	}
+	content, err = json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, result)
+}
+
+// callFarmerBot answers the jobs of the farms whose twins run a bot,
+// powering on the sleeping nodes of the grid proxy mock when they are picked
+func (r *RMBClientMock) callFarmerBot(twin uint32, fn string, data interface{}, result interface{}) error {
+	if fn != FarmerBotRMBFunction {
+		return fmt.Errorf("fn: %s not supported", fn)
+	}
+	farmID, ok := r.farms[twin]
+	if !ok {
+		return fmt.Errorf("twin %d doesn't run a farmer bot", twin)
+	}
+
+	job := data.(FarmerBotAction)
+	r.jobs = append(r.jobs, job)
+	output := result.(*FarmerBotAction)
+	*output = job
+	output.State = "done"
+
+	switch job.Action {
+	case FarmerBotVersionAction:
+		output.Result.Params = []Params{{Key: farmerBotVersionKey, Value: "0.1.0"}}
+	case FarmerBotFindNodeAction:
+		node, ok := r.findNode(farmID, job.Args)
+		if !ok {
+			output.State = "error"
+			output.Error = "could not find a node with the required resources"
+			return nil
+		}
+		if node.Status != "up" {
+			r.wake(uint32(node.NodeID))
+		}
+		output.Result.Params = []Params{{Key: farmerBotNodeIDKey, Value: strconv.Itoa(node.NodeID)}}
+	default:
+		output.State = "error"
+		output.Error = fmt.Sprintf("unknown action %s", job.Action)
+	}
+	return nil
+}
+
+// findNode picks the first node of the farm with the required resources of the job params, even if it's sleeping
+func (r *RMBClientMock) findNode(farmID int, args FarmerBotArgs) (proxyTypes.Node, bool) {
+	params := map[string]interface{}{}
+	for _, param := range args.Params {
+		params[param.Key] = param.Value
+	}
+	has := func(total, used uint64, key string) bool {
+		required, ok := params[key].(uint64)
+		return !ok || total >= used+required
+	}
+	excluded := func(nodeID int) bool {
+		exclude, _ := params["node_exclude"].(string)
+		return contains(strings.Split(strings.Trim(exclude, "[]"), ","), strconv.Itoa(nodeID))
+	}
+
+	nodes := append([]proxyTypes.Node{}, r.proxy.nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	for _, node := range nodes {
+		total, used := node.TotalResources, node.UsedResources
+		publicIPs, _ := params["public_ips"].(uint32)
+		switch {
+		case node.FarmID != farmID,
+			node.Status == statusDown,
+			excluded(node.NodeID),
+			!has(total.CRU, used.CRU, "required_cru"),
+			!has(uint64(total.MRU), uint64(used.MRU), "required_mru"),
+			!has(uint64(total.SRU), uint64(used.SRU), "required_sru"),
+			!has(uint64(total.HRU), uint64(used.HRU), "required_hru"),
+			params["public_config"] == true && node.PublicConfig.Domain == "",
+			getPublicIPsCount(r.proxy.farm(farmID).PublicIps) < uint64(publicIPs):
+			continue
+		}
+		return node, true
+	}
+	return proxyTypes.Node{}, false
+}
+
+// wake powers on a sleeping node, it's up in the grid proxy after a few status checks
+func (r *RMBClientMock) wake(nodeID uint32) {
+	for i := range r.proxy.nodes {
+		if uint32(r.proxy.nodes[i].NodeID) == nodeID {
+			r.proxy.nodes[i].Status = "up"
+			if r.proxy.poweringOn == nil {
+				r.proxy.poweringOn = map[uint32]int{}
+			}
+			r.proxy.poweringOn[nodeID] = r.powerOnChecks
+		}
+	}
+}
+
+// nodeClientsMock gets the clients of the nodes known by the grid proxy mock, without a substrate connection
+type nodeClientsMock struct {
+	proxy *GridProxyClientMock
+	rmb   rmb.Client
+}
+
+func (m nodeClientsMock) GetNodeClient(sub subi.SubstrateExt, nodeID uint32) (*client.NodeClient, error) {
+	for _, node := range m.proxy.nodes {
+		if uint32(node.NodeID) == nodeID {
+			return client.NewNodeClient(uint32(node.TwinID), m.rmb, time.Second), nil
+		}
+	}
+	return nil, fmt.Errorf("node %d not found", nodeID)
+}
+
 func (m *GridProxyClientMock) Ping() error {
 	return nil
 }
 
 func (m *GridProxyClientMock) Nodes(filter proxyTypes.NodeFilter, pagination proxyTypes.Limit) (res []proxyTypes.Node, totalCount int, err error) {
-	start, end := (pagination.Page-1)*pagination.Size, pagination.Page*pagination.Size
-	if int(end) > len(m.nodes) {
-		end = uint64(len(m.nodes))
+	m.nodePages++
+	nodes := make([]proxyTypes.Node, 0, len(m.nodes))
+	for _, node := range m.nodes {
+		if m.matches(filter, node) {
+			nodes = append(nodes, node)
+		}
 	}
-	if end <= start {
-		return make([]proxyTypes.Node, 0), 0, nil
+	return paginate(nodes, pagination), len(nodes), nil
+}
+
+// matches checks the node against the filters set by the scheduler
+func (m *GridProxyClientMock) matches(f proxyTypes.NodeFilter, node proxyTypes.Node) bool {
+	farm := m.farm(node.FarmID)
+	free := func(total, used uint64) uint64 {
+		if used > total {
+			return 0
+		}
+		return total - used
 	}
-	res = m.nodes[start:end]
-	return
+	rentedByOther := func(twinID uint64) bool {
+		return node.RentedByTwinID != 0 && uint64(node.RentedByTwinID) != twinID
+	}
+
+	switch {
+	case f.Status != nil && node.Status != *f.Status,
+		f.FreeMRU != nil && free(uint64(node.TotalResources.MRU), uint64(node.UsedResources.MRU)) < *f.FreeMRU,
+		f.FreeSRU != nil && free(uint64(node.TotalResources.SRU), uint64(node.UsedResources.SRU)) < *f.FreeSRU,
+		f.FreeHRU != nil && free(uint64(node.TotalResources.HRU), uint64(node.UsedResources.HRU)) < *f.FreeHRU,
+		f.TotalCRU != nil && node.TotalResources.CRU < *f.TotalCRU,
+		f.Country != nil && node.Country != *f.Country,
+		f.City != nil && node.City != *f.City,
+		len(f.FarmIDs) != 0 && !contains(f.FarmIDs, uint64(node.FarmID)),
+		f.FreeIPs != nil && getPublicIPsCount(farm.PublicIps) < *f.FreeIPs,
+		f.IPv4 != nil && *f.IPv4 != (node.PublicConfig.Ipv4 != ""),
+		f.IPv6 != nil && *f.IPv6 != (node.PublicConfig.Ipv6 != ""),
+		f.Domain != nil && *f.Domain != (node.PublicConfig.Domain != ""),
+		f.Rentable != nil && *f.Rentable != (node.RentedByTwinID == 0 && (node.Dedicated || farm.Dedicated)),
+		f.RentedBy != nil && uint64(node.RentedByTwinID) != *f.RentedBy,
+		f.AvailableFor != nil && rentedByOther(*f.AvailableFor),
+		f.NodeID != nil && uint64(node.NodeID) != *f.NodeID:
+		return false
+	}
+	return true
+}
+
+func (m *GridProxyClientMock) farm(farmID int) proxyTypes.Farm {
+	for _, farm := range m.farms {
+		if farm.FarmID == farmID {
+			return farm
+		}
+	}
+	return proxyTypes.Farm{}
 }
 
 func (m *GridProxyClientMock) Farms(filter proxyTypes.FarmFilter, pagination proxyTypes.Limit) (res []proxyTypes.Farm, totalCount int, err error) {
+	m.farmQueries++
+	farms := make([]proxyTypes.Farm, 0, len(m.farms))
+	for _, farm := range m.farms {
+		if filter.FarmID != nil && uint64(farm.FarmID) != *filter.FarmID {
+			continue
+		}
+		if filter.CertificationType != nil && farm.CertificationType != *filter.CertificationType {
+			continue
+		}
+		farms = append(farms, farm)
+	}
+	return paginate(farms, pagination), len(farms), nil
+}
+
+func paginate[T any](elements []T, pagination proxyTypes.Limit) []T {
 	start, end := (pagination.Page-1)*pagination.Size, pagination.Page*pagination.Size
-	if int(end) > len(m.nodes) {
-		end = uint64(len(m.nodes))
+	if int(end) > len(elements) {
+		end = uint64(len(elements))
 	}
 	if end <= start {
-		return make([]proxyTypes.Farm, 0), 0, nil
+		return make([]T, 0)
 	}
-	res = m.farms[start:end]
-	return
+	return elements[start:end]
 }
 
 func (m *GridProxyClientMock) Node(nodeID uint32) (res proxyTypes.NodeWithNestedCapacity, err error) {
 	for _, node := range m.nodes {
-		if uint32(node.NodeID) == nodeID {
-			res = proxyTypes.NodeWithNestedCapacity{
-				Capacity: proxyTypes.CapacityResult{
-					Total: node.TotalResources,
-					Used:  node.UsedResources,
-				},
-			}
-			return
+		if uint32(node.NodeID) != nodeID {
+			continue
 		}
+		if m.poweringOn[nodeID] > 0 {
+			m.poweringOn[nodeID]--
+			node.Status = "standby"
+		}
+		return proxyTypes.NodeWithNestedCapacity{
+			NodeID:            node.NodeID,
+			FarmID:            node.FarmID,
+			TwinID:            node.TwinID,
+			Country:           node.Country,
+			City:              node.City,
+			Uptime:            node.Uptime,
+			PublicConfig:      node.PublicConfig,
+			Status:            node.Status,
+			CertificationType: node.CertificationType,
+			Dedicated:         node.Dedicated,
+			RentContractID:    node.RentContractID,
+			RentedByTwinID:    node.RentedByTwinID,
+			Capacity: proxyTypes.CapacityResult{
+				Total: node.TotalResources,
+				Used:  node.UsedResources,
+			},
+		}, nil
 	}
-	err = fmt.Errorf("node not found")
-	return
+	return res, fmt.Errorf("node %d not found", nodeID)
 }
 
 func (m *GridProxyClientMock) NodeStatus(nodeID uint32) (res proxyTypes.NodeStatus, err error) {
@@ -95,11 +365,27 @@ func (m *GridProxyClientMock) AddFarm(farm proxyTypes.Farm) {
 	m.farms = append(m.farms, farm)
 }
 
+// AddNode adds a node to the grid proxy, it's up unless it has another status
 func (m *GridProxyClientMock) AddNode(id uint32, node proxyTypes.Node) {
+	if node.Status == "" {
+		node.Status = statusUp
+	}
 	m.nodes = append(m.nodes, node)
 }
 func (m *GridProxyClientMock) Contracts(filter proxyTypes.ContractFilter, pagination proxyTypes.Limit) (res []proxyTypes.Contract, totalCount int, err error) {
-	return
+	contracts := []proxyTypes.Contract{}
+	for _, contract := range m.contracts {
+		details, _ := contract.Details.(proxyTypes.NodeContractDetails)
+		switch {
+		case filter.TwinID != nil && uint64(contract.TwinID) != *filter.TwinID,
+			filter.NodeID != nil && uint64(details.NodeID) != *filter.NodeID,
+			filter.Type != nil && contract.Type != *filter.Type,
+			filter.State != nil && contract.State != *filter.State:
+			continue
+		}
+		contracts = append(contracts, contract)
+	}
+	return paginate(contracts, pagination), len(contracts), nil
 }
 func (m *GridProxyClientMock) Twins(filter proxyTypes.TwinFilter, pagination proxyTypes.Limit) (res []proxyTypes.Twin, totalCount int, err error) {
 	return
@@ -138,6 +424,7 @@ func TestSchedulerSuccess(t *testing.T) {
 	}
 	proxy.AddNode(1, proxyTypes.Node{
 		NodeID: 1,
+		Status: "up",
 		TotalResources: proxyTypes.Capacity{
 			HRU: 5,
 			SRU: 10,
@@ -192,6 +479,7 @@ func TestSchedulerSuccessOn4thPage(t *testing.T) {
 	}
 	proxy.AddNode(1, proxyTypes.Node{
 		NodeID: 1,
+		Status: "up",
 		TotalResources: proxyTypes.Capacity{
 			HRU: 5,
 			SRU: 10,
@@ -360,6 +648,7 @@ func TestSchedulerSuccessAfterSuccess(t *testing.T) {
 	}
 	proxy.AddNode(1, proxyTypes.Node{
 		NodeID: 1,
+		Status: "up",
 		TotalResources: proxyTypes.Capacity{
 			HRU: 5,
 			SRU: 10,
@@ -384,6 +673,9 @@ func TestSchedulerSuccessAfterSuccess(t *testing.T) {
 			{
 				IP: "a",
 			},
+			{
+				IP: "b",
+			},
 		},
 	})
 	scheduler := NewScheduler(proxy, 1, rmbClient)
@@ -491,3 +783,404 @@ func TestDistinctNodes(t *testing.T) {
 	assert.NotEqual(t, assignment["r1"], assignment["r3"])
 	assert.NotEqual(t, assignment["r2"], assignment["r3"])
 }
+
+func TestSchedulerCPUFailureAfterSuccess(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: false,
+	}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID: 1,
+		TotalResources: proxyTypes.Capacity{
+			CRU: 4,
+			MRU: 15,
+		},
+		UsedResources: proxyTypes.Capacity{
+			CRU: 1,
+			MRU: 4,
+		},
+		FarmID: 1,
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{
+		Capacity: Capacity{
+			CRU: 2,
+			MRU: 1,
+		},
+		Name: "req",
+	})
+	assert.NoError(t, err, "there's a satisfying node")
+	assert.Equal(t, nodeID, uint32(1), "the node id should be 1")
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		Capacity: Capacity{
+			CRU: 2, // this violates
+			MRU: 1,
+		},
+		Name: "req",
+	})
+	assert.Error(t, err, "node cpus would be oversubscribed")
+}
+
+func TestFarmerBotParamsCPU(t *testing.T) {
+	params := buildFarmerBotParams(&Request{
+		Capacity: Capacity{
+			CRU: 2,
+		},
+	})
+	assert.Equal(t, params, []Params{{Key: "required_cru", Value: uint64(2)}})
+}
+
+func TestSchedulerMinZosVersion(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		zosVersions: map[uint32]string{11: "v3.5.2", 12: "v3.6.0", 14: "v3.8.0"},
+	}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:  1,
+		TwinID:  11,
+		FarmID:  1,
+		Country: "Belgium",
+	})
+	proxy.AddNode(2, proxyTypes.Node{
+		NodeID:  2,
+		TwinID:  12,
+		FarmID:  1,
+		Country: "Belgium",
+	})
+	// node 3 doesn't answer
+	proxy.AddNode(3, proxyTypes.Node{
+		NodeID:  3,
+		TwinID:  13,
+		FarmID:  1,
+		Country: "Belgium",
+	})
+	proxy.AddNode(4, proxyTypes.Node{
+		NodeID:  4,
+		TwinID:  14,
+		FarmID:  1,
+		Country: "Egypt",
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	scheduler.SetNodeClients(nodeClientsMock{proxy: proxy, rmb: rmbClient}, nil)
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{
+		MinZosVersion: "3.6",
+		Country:       "Belgium",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), nodeID)
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		MinZosVersion: "3.7",
+		Country:       "Belgium",
+	})
+	assert.Error(t, err, "no node in Belgium runs zos 3.7")
+	assert.ElementsMatch(t, []uint32{11, 12, 13}, rmbClient.zosVersionCalls, "each candidate is asked once, even if it didn't answer")
+}
+
+func TestSchedulerFetchesCandidateFarms(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	for i := 1; i <= 3; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, FarmID: i, Country: "Belgium"})
+		proxy.AddFarm(proxyTypes.Farm{FarmID: i})
+	}
+	proxy.AddNode(4, proxyTypes.Node{NodeID: 4, FarmID: 4, Country: "Egypt"})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 4})
+
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", Country: "Egypt"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), nodeID)
+	assert.Equal(t, 1, proxy.farmQueries, "only the farm of the node in Egypt is fetched")
+}
+
+func TestSchedulerCertification(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{}
+	// the only certified node is on the 3rd page
+	for i := 2; i <= 2*nodesPageSize+5; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, FarmID: 1, CertificationType: "Diy"})
+	}
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 2, CertificationType: "Certified"})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, CertificationType: "NotCertified"})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 2, CertificationType: "Gold"})
+
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{Certified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), nodeID)
+
+	scheduler = NewScheduler(proxy, 1, rmbClient)
+	nodeID, err = scheduler.Schedule(context.Background(), &Request{FarmCertification: "Gold"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), nodeID)
+
+	scheduler = NewScheduler(proxy, 1, rmbClient)
+	_, err = scheduler.Schedule(context.Background(), &Request{Certified: true, NodeExclude: []uint32{1}})
+	assert.Error(t, err, "paging should stop at the last page")
+
+	_, err = scheduler.Schedule(context.Background(), &Request{FarmCertification: "Silver"})
+	assert.Error(t, err, "there are no silver farms")
+}
+
+// fakeGrid has two farms in different countries, farm 1 is dedicated and farm 2 has a single public ip
+func fakeGrid() *GridProxyClientMock {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 11, Dedicated: true})
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 2, TwinID: 12, PublicIps: []proxyTypes.PublicIP{{IP: "a"}}})
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:         1,
+		FarmID:         1,
+		Country:        "Belgium",
+		Status:         "up",
+		Dedicated:      true,
+		TotalResources: proxyTypes.Capacity{CRU: 4, MRU: 8 * gridtypes.Gigabyte, SRU: 100 * gridtypes.Gigabyte},
+	})
+	proxy.AddNode(2, proxyTypes.Node{
+		NodeID:         2,
+		FarmID:         1,
+		Country:        "Belgium",
+		Status:         "up",
+		Dedicated:      true,
+		RentedByTwinID: 5,
+		TotalResources: proxyTypes.Capacity{CRU: 16, MRU: 64 * gridtypes.Gigabyte, SRU: 1000 * gridtypes.Gigabyte},
+	})
+	proxy.AddNode(3, proxyTypes.Node{
+		NodeID:         3,
+		FarmID:         2,
+		Country:        "Egypt",
+		Status:         "up",
+		PublicConfig:   proxyTypes.PublicConfig{Domain: "node3.grid.tf", Ipv4: "1.1.1.1/24"},
+		TotalResources: proxyTypes.Capacity{CRU: 8, MRU: 16 * gridtypes.Gigabyte, SRU: 500 * gridtypes.Gigabyte},
+		UsedResources:  proxyTypes.Capacity{MRU: 12 * gridtypes.Gigabyte},
+	})
+	proxy.AddNode(4, proxyTypes.Node{
+		NodeID:         4,
+		FarmID:         2,
+		Country:        "Egypt",
+		Status:         "down",
+		TotalResources: proxyTypes.Capacity{CRU: 32, MRU: 128 * gridtypes.Gigabyte},
+	})
+	return proxy
+}
+
+func TestGridProxySchedule(t *testing.T) {
+	gb := uint64(gridtypes.Gigabyte)
+	cases := []struct {
+		name     string
+		twinID   uint64
+		request  Request
+		expected uint32
+	}{
+		{"fits", 1, Request{Capacity: Capacity{CRU: 2, MRU: 2 * gb, SRU: 200 * gb}}, 3},
+		{"total cpus", 1, Request{Capacity: Capacity{CRU: 6}}, 3},
+		{"free memory", 1, Request{Capacity: Capacity{MRU: 6 * gb}}, 1},
+		{"rented by someone else", 1, Request{Capacity: Capacity{CRU: 10}}, 0},
+		{"rented by me", 5, Request{Capacity: Capacity{CRU: 10}}, 2},
+		{"only my rented nodes", 5, Request{RentedByMe: true}, 2},
+		{"farm", 1, Request{FarmId: 2}, 3},
+		{"country", 1, Request{Country: "Egypt"}, 3},
+		{"no node in country", 1, Request{Country: "Ghana"}, 0},
+		{"public config", 1, Request{PublicConfig: true}, 3},
+		{"ipv4", 1, Request{IPv4: true}, 3},
+		{"public ips", 1, Request{PublicIpsCount: 1}, 3},
+		{"not enough public ips", 1, Request{PublicIpsCount: 2}, 0},
+		{"dedicated", 1, Request{Dedicated: true}, 1},
+		{"excluded nodes", 1, Request{NodeExclude: []uint32{1, 3}}, 0},
+		{"down nodes", 1, Request{Capacity: Capacity{CRU: 20}}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scheduler := NewScheduler(fakeGrid(), c.twinID, &RMBClientMock{})
+			c.request.Name = "req"
+			nodeID, err := scheduler.gridProxySchedule(context.Background(), &c.request, func(*nodeInfo) bool { return true }, &nodeListing{})
+			if c.expected == 0 {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, nodeID)
+		})
+	}
+}
+
+func TestGridProxySchedulePaging(t *testing.T) {
+	cases := []struct {
+		name     string
+		nodes    int
+		fitting  int
+		expected uint32
+		pages    int
+	}{
+		{"first page", 3 * nodesPageSize, 1, 1, 1},
+		{"last page", 3*nodesPageSize + 5, 3*nodesPageSize + 5, 3*nodesPageSize + 5, 4},
+		{"full last page", 2 * nodesPageSize, 2 * nodesPageSize, 2 * nodesPageSize, 2},
+		{"no fitting node", 2*nodesPageSize + 1, 0, 0, 3},
+		{"empty page after a full one", nodesPageSize, 0, 0, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			proxy := &GridProxyClientMock{}
+			proxy.AddFarm(proxyTypes.Farm{FarmID: 1})
+			for i := 1; i <= c.nodes; i++ {
+				node := proxyTypes.Node{NodeID: i, FarmID: 1, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 2}}
+				// the grid proxy can't filter on free cpus, only the scheduler can
+				if i != c.fitting {
+					node.UsedResources.CRU = 2
+				}
+				proxy.AddNode(uint32(i), node)
+			}
+
+			scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+			nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", Capacity: Capacity{CRU: 1}})
+			if c.expected == 0 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, c.expected, nodeID)
+			}
+			assert.Equal(t, c.pages, proxy.nodePages)
+		})
+	}
+}
+
+func TestProcessRequestsDistinct(t *testing.T) {
+	cases := []struct {
+		name     string
+		nodes    int
+		requests int
+		assigned map[string]uint32
+		err      bool
+	}{
+		{"a node for each request", 3, 3, map[string]uint32{}, false},
+		{"more requests than nodes", 2, 3, map[string]uint32{}, true},
+		{"assigned nodes are kept", 3, 3, map[string]uint32{"req1": 2}, false},
+		{"assigned nodes are excluded", 2, 3, map[string]uint32{"req0": 1}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			proxy := &GridProxyClientMock{}
+			proxy.AddFarm(proxyTypes.Farm{FarmID: 1})
+			for i := 1; i <= c.nodes; i++ {
+				proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, FarmID: 1, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+			}
+			requests := []Request{}
+			for i := 0; i < c.requests; i++ {
+				requests = append(requests, Request{Name: fmt.Sprintf("req%d", i), Distinct: true, Capacity: Capacity{CRU: 1}})
+			}
+			assignment := map[string]uint32{}
+			for name, node := range c.assigned {
+				assignment[name] = node
+			}
+
+			scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+			err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, assignment, c.requests)
+			used := map[uint32]bool{}
+			for name, node := range assignment {
+				assert.False(t, used[node], "request %s shares node %d", name, node)
+				used[node] = true
+			}
+			for name, node := range c.assigned {
+				assert.Equal(t, node, assignment[name], "request %s should keep its node", name)
+			}
+		})
+	}
+}
+
+func TestPublicIPsAccounting(t *testing.T) {
+	cases := []struct {
+		name     string
+		requests []Request
+		err      bool
+		farms    map[uint32]uint64
+	}{
+		{
+			name:     "without farm id",
+			requests: []Request{{Name: "a", PublicIpsCount: 1}, {Name: "b", PublicIpsCount: 1}},
+			farms:    map[uint32]uint64{1: 0, 2: 0},
+		},
+		{
+			name:     "more ips than the farms have",
+			requests: []Request{{Name: "a", PublicIpsCount: 1}, {Name: "b", PublicIpsCount: 1}, {Name: "c", PublicIpsCount: 1}},
+			err:      true,
+		},
+		{
+			name:     "same farm",
+			requests: []Request{{Name: "a", FarmId: 1, PublicIpsCount: 1}, {Name: "b", FarmId: 1, PublicIpsCount: 1}},
+			err:      true,
+		},
+		{
+			name:     "several ips in a request",
+			requests: []Request{{Name: "a", PublicIpsCount: 2}},
+			err:      true,
+		},
+		{
+			name:     "requests without ips",
+			requests: []Request{{Name: "a", FarmId: 1, PublicIpsCount: 1}, {Name: "b", FarmId: 1}},
+			farms:    map[uint32]uint64{1: 0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			proxy := &GridProxyClientMock{}
+			// each farm has one free ip and one used ip
+			for farmID := 1; farmID <= 2; farmID++ {
+				proxy.AddFarm(proxyTypes.Farm{FarmID: farmID, PublicIps: []proxyTypes.PublicIP{{IP: "a"}, {IP: "b", ContractID: 5}}})
+				for i := 1; i <= 2; i++ {
+					nodeID := 2*(farmID-1) + i
+					proxy.AddNode(uint32(nodeID), proxyTypes.Node{NodeID: nodeID, FarmID: farmID, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+				}
+			}
+
+			scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+			assignment := map[string]uint32{}
+			err := scheduler.ProcessRequests(context.Background(), c.requests, assignment)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			for farmID, freeIPs := range c.farms {
+				assert.Equal(t, freeIPs, scheduler.farms[farmID].freeIPs, "farm %d", farmID)
+			}
+		})
+	}
+}
+
+func TestReleasePublicIPs(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, PublicIps: []proxyTypes.PublicIP{{IP: "a"}, {IP: "b"}}})
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+
+	r := &Request{Name: "a", PublicIpsCount: 2}
+	scheduler.consume(1, r)
+	assert.Equal(t, uint64(0), scheduler.farms[1].freeIPs)
+	scheduler.release(1, r)
+	assert.Equal(t, uint64(2), scheduler.farms[1].freeIPs)
+}
+
+func TestFarmerBotPublicIPs(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1, TwinID: 11, PublicIps: []proxyTypes.PublicIP{{IP: "a"}}})
+	proxy.AddNode(1, proxyTypes.Node{NodeID: 1, FarmID: 1, Status: "up", TotalResources: proxyTypes.Capacity{CRU: 8}})
+	bots := &RMBClientMock{proxy: proxy, farms: map[uint32]int{11: 1}}
+	scheduler := NewScheduler(proxy, 1, bots)
+
+	_, err := scheduler.Schedule(context.Background(), &Request{Name: "a", FarmId: 1, PublicIpsCount: 1})
+	assert.NoError(t, err)
+	_, err = scheduler.Schedule(context.Background(), &Request{Name: "b", FarmId: 1, PublicIpsCount: 1})
+	assert.ErrorContains(t, err, "farm 1 has 0 free public ips left")
+}
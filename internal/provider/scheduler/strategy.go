@@ -0,0 +1,114 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Ranking strategies of the candidate nodes
+const (
+	// RandomStrategy picks a random fitting node
+	RandomStrategy = "random"
+	// BinPackingStrategy fills the most loaded nodes first
+	BinPackingStrategy = "bin_packing"
+	// SpreadStrategy picks the least loaded nodes first
+	SpreadStrategy = "spread"
+	// CheapestStrategy picks the nodes where the request costs the least first
+	CheapestStrategy = "cheapest"
+	// UptimeStrategy picks the nodes with the highest uptime first
+	UptimeStrategy = "uptime"
+)
+
+// Strategies lists the supported ranking strategies
+var Strategies = []string{RandomStrategy, BinPackingStrategy, SpreadStrategy, CheapestStrategy, UptimeStrategy}
+
+// strategy orders the candidate nodes of a request, the scheduler picks the first fitting one
+type strategy interface {
+	rank(r *Request, nodes []*nodeInfo)
+}
+
+type randomStrategy struct {
+	rand *rand.Rand
+}
+
+func (s randomStrategy) rank(r *Request, nodes []*nodeInfo) {
+	s.rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+}
+
+type binPackingStrategy struct{}
+
+func (binPackingStrategy) rank(r *Request, nodes []*nodeInfo) {
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].load() > nodes[j].load() })
+}
+
+type spreadStrategy struct{}
+
+func (spreadStrategy) rank(r *Request, nodes []*nodeInfo) {
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].load() < nodes[j].load() })
+}
+
+type cheapestStrategy struct {
+	cost func(r *Request, node *nodeInfo) float64
+}
+
+func (s cheapestStrategy) rank(r *Request, nodes []*nodeInfo) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return s.cost(r, nodes[i]) < s.cost(r, nodes[j])
+	})
+}
+
+type uptimeStrategy struct{}
+
+func (uptimeStrategy) rank(r *Request, nodes []*nodeInfo) {
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Node.Uptime > nodes[j].Node.Uptime })
+}
+
+// load is the average used ratio of the node's resources, including the ones consumed by this scheduler
+func (node *nodeInfo) load() float64 {
+	total := node.Node.TotalResources
+	ratios := []struct{ total, free uint64 }{
+		{total.CRU, node.FreeCapacity.CRU},
+		{uint64(total.MRU), node.FreeCapacity.MRU},
+		{uint64(total.SRU), node.FreeCapacity.SRU},
+		{uint64(total.HRU), node.FreeCapacity.HRU},
+	}
+
+	var load float64
+	count := 0
+	for _, ratio := range ratios {
+		if ratio.total == 0 {
+			continue
+		}
+		load += float64(ratio.total-ratio.free) / float64(ratio.total)
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return load / float64(count)
+}
+
+// ValidateStrategy checks the strategy is supported, an empty strategy is random
+func ValidateStrategy(name string) error {
+	if name == "" || contains(Strategies, name) {
+		return nil
+	}
+	return fmt.Errorf("unknown strategy '%s', expected one of: %s %s %s %s %s", name, RandomStrategy, BinPackingStrategy, SpreadStrategy, CheapestStrategy, UptimeStrategy)
+}
+
+func (s *Scheduler) strategy(name string) strategy {
+	switch name {
+	case BinPackingStrategy:
+		return binPackingStrategy{}
+	case SpreadStrategy:
+		return spreadStrategy{}
+	case CheapestStrategy:
+		return cheapestStrategy{cost: s.requestCost}
+	case UptimeStrategy:
+		return uptimeStrategy{}
+	default:
+		return randomStrategy{rand: s.rand}
+	}
+}
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+func strategyProxy() *GridProxyClientMock {
+	proxy := &GridProxyClientMock{}
+	// node 1 is half loaded and certified, node 2 is empty, node 3 is almost full
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:            1,
+		FarmID:            1,
+		Uptime:            10,
+		CertificationType: "Certified",
+		TotalResources:    proxyTypes.Capacity{CRU: 4, MRU: 8 * gridtypes.Gigabyte},
+		UsedResources:     proxyTypes.Capacity{CRU: 2, MRU: 4 * gridtypes.Gigabyte},
+	})
+	proxy.AddNode(2, proxyTypes.Node{
+		NodeID:         2,
+		FarmID:         1,
+		Uptime:         30,
+		TotalResources: proxyTypes.Capacity{CRU: 4, MRU: 8 * gridtypes.Gigabyte},
+	})
+	proxy.AddNode(3, proxyTypes.Node{
+		NodeID:            3,
+		FarmID:            1,
+		Uptime:            20,
+		CertificationType: "Certified",
+		TotalResources:    proxyTypes.Capacity{CRU: 4, MRU: 8 * gridtypes.Gigabyte},
+		UsedResources:     proxyTypes.Capacity{CRU: 3, MRU: 7 * gridtypes.Gigabyte},
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	return proxy
+}
+
+func TestStrategies(t *testing.T) {
+	expected := map[string]uint32{
+		BinPackingStrategy: 3,
+		SpreadStrategy:     2,
+		CheapestStrategy:   2,
+		UptimeStrategy:     2,
+	}
+	for strategy, node := range expected {
+		scheduler := NewScheduler(strategyProxy(), 1, &RMBClientMock{})
+		nodeID, err := scheduler.Schedule(context.Background(), &Request{
+			Capacity: Capacity{
+				CRU: 1,
+				MRU: uint64(gridtypes.Gigabyte),
+			},
+			Strategy: strategy,
+		})
+		assert.NoError(t, err, strategy)
+		assert.Equal(t, node, nodeID, strategy)
+	}
+}
+
+func TestBinPackingFillsNodes(t *testing.T) {
+	scheduler := NewScheduler(strategyProxy(), 1, &RMBClientMock{})
+	assignment := map[string]uint32{}
+	requests := []Request{
+		{Name: "r1", Capacity: Capacity{CRU: 1}, Strategy: BinPackingStrategy},
+		{Name: "r2", Capacity: Capacity{CRU: 1}, Strategy: BinPackingStrategy},
+	}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	// node 3 is full after the first request
+	assert.Equal(t, map[string]uint32{"r1": 3, "r2": 1}, assignment)
+}
+
+func TestSeededRandomStrategy(t *testing.T) {
+	requests := []Request{{Name: "r1"}, {Name: "r2"}, {Name: "r3"}, {Name: "r4"}}
+
+	plan := func() map[string]uint32 {
+		scheduler := NewScheduler(strategyProxy(), 1, &RMBClientMock{})
+		scheduler.SetSeed(42)
+		assignment := map[string]uint32{}
+		assert.NoError(t, scheduler.ProcessRequests(context.Background(), requests, assignment))
+		return assignment
+	}
+
+	expected := plan()
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, expected, plan(), "seeded plans should be the same")
+	}
+}
+
+func TestPreferRentedByMe(t *testing.T) {
+	proxy := strategyProxy()
+	proxy.nodes[2].RentedByTwinID = 1
+
+	for _, strategy := range Strategies {
+		scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+		nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", Strategy: strategy, PreferRentedByMe: true})
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(3), nodeID, "strategy %s should pick the rented node first", strategy)
+	}
+
+	// the other nodes are still candidates
+	scheduler := NewScheduler(proxy, 1, &RMBClientMock{})
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{Name: "req", Capacity: Capacity{CRU: 2}, PreferRentedByMe: true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, uint32(3), nodeID)
+}
+
+func TestValidateStrategy(t *testing.T) {
+	assert.NoError(t, ValidateStrategy(""))
+	for _, strategy := range Strategies {
+		assert.NoError(t, ValidateStrategy(strategy))
+	}
+	assert.Error(t, ValidateStrategy("fastest"))
+}
+
+func TestMonthlyCost(t *testing.T) {
+	policy := PricingPolicy{CU: 1, SU: 1, IPU: 1, CertifiedMarkup: 0.25, DedicatedDiscount: 0.5}
+	c := Capacity{
+		CRU: 2,
+		MRU: 4 * uint64(gridtypes.Gigabyte),
+		SRU: 200 * uint64(gridtypes.Gigabyte),
+	}
+	// 1 cu + 1 su + 1 ip
+	assert.Equal(t, 3.0*hoursPerMonth, policy.MonthlyCost(c, 1, false, false))
+	assert.Equal(t, 3.75*hoursPerMonth, policy.MonthlyCost(c, 1, true, false))
+	assert.Equal(t, 1.5*hoursPerMonth, policy.MonthlyCost(c, 1, false, true))
+}
@@ -0,0 +1,155 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	client "github.com/threefoldtech/tfgrid-sdk-go/grid-client/node"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+)
+
+// SetNodeClients sets the node clients used to ask the nodes for their zos versions
+func (n *Scheduler) SetNodeClients(nodeClients client.NodeClientGetter, sub subi.SubstrateExt) {
+	n.nodeClients = nodeClients
+	n.sub = sub
+}
+
+const (
+	// zosVersionWorkers is the count of nodes asked for their zos version at the same time
+	zosVersionWorkers = 10
+	// zosVersionTimeout is how long to wait for a node to answer with its zos version
+	zosVersionTimeout = 10 * time.Second
+)
+
+// loadZosVersions fetches the zos versions of the known nodes if the request needs them.
+// Only the nodes fulfilling the other requirements of the request are asked, each of them once even if it doesn't answer.
+func (n *Scheduler) loadZosVersions(ctx context.Context, r *Request) {
+	if r.MinZosVersion == "" {
+		return
+	}
+	if n.nodeClients == nil {
+		log.Printf("couldn't get the zos versions of the nodes: no node clients are set")
+		return
+	}
+
+	others := *r
+	others.MinZosVersion = ""
+	ids := []uint32{}
+	for id, node := range n.nodes {
+		if node.ZosVersionLoaded {
+			continue
+		}
+		if node.localRejection(&others, n.twinID) != "" {
+			continue
+		}
+		farm, err := n.getFarmInfo(uint32(node.Node.FarmID))
+		if err != nil || farmRejection(&others, farm) != "" {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	versions := make([]string, len(ids))
+	workers := make(chan struct{}, zosVersionWorkers)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, id uint32) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			version, err := n.zosVersion(ctx, id)
+			if err != nil {
+				log.Printf("couldn't get node %d zos version: %s", id, err)
+				return
+			}
+			versions[i] = version
+		}(i, id)
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		node := n.nodes[id]
+		node.ZosVersion = versions[i]
+		node.ZosVersionLoaded = true
+		n.nodes[id] = node
+	}
+}
+
+// zosVersion asks a node for its zos version
+func (n *Scheduler) zosVersion(ctx context.Context, nodeID uint32) (string, error) {
+	if n.nodeClients == nil {
+		return "", errors.New("no node clients are set")
+	}
+	nodeClient, err := n.nodeClients.GetNodeClient(n.sub, nodeID)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't get node %d client", nodeID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, zosVersionTimeout)
+	defer cancel()
+	version, err := nodeClient.SystemVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return version.ZOS, nil
+}
+
+// compareVersions compares two dotted versions like v3.6.1, ignoring any pre-release suffix.
+// It returns -1, 0 or 1, an empty or invalid version is lower than any valid one.
+func compareVersions(a, b string) int {
+	as, aOk := parseVersion(a)
+	bs, bOk := parseVersion(b)
+	switch {
+	case !aOk && !bOk:
+		return 0
+	case !aOk:
+		return -1
+	case !bOk:
+		return 1
+	}
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+ "); i != -1 {
+		version = version[:i]
+	}
+	if version == "" {
+		return nil, false
+	}
+
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, number)
+	}
+	return parts, true
+}
@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v3.6.1", "3.6.1", 0},
+		{"3.6", "3.6.0", 0},
+		{"v3.6.1", "v3.6.0", 1},
+		{"v3.5.9", "v3.6", -1},
+		{"v3.10.0", "v3.9.0", 1},
+		{"3.6.1-rc2", "3.6.1", 0},
+		{"", "3.0", -1},
+		{"main", "3.0", -1},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, compareVersions(c.a, c.b), "%s vs %s", c.a, c.b)
+	}
+}
+
+func TestLoadZosVersionsConcurrently(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	proxy.AddFarm(proxyTypes.Farm{FarmID: 1})
+	rmbClient := &RMBClientMock{zosVersions: map[uint32]string{}, zosVersionDelay: 10 * time.Millisecond}
+	nodes := 3 * zosVersionWorkers
+	for i := 1; i <= nodes; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{NodeID: i, TwinID: 100 + i, FarmID: 1, Status: "up"})
+		rmbClient.zosVersions[uint32(100+i)] = fmt.Sprintf("v3.%d.0", i)
+	}
+
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	scheduler.SetNodeClients(nodeClientsMock{proxy: proxy, rmb: rmbClient}, nil)
+	nodeID, err := scheduler.Schedule(context.Background(), &Request{MinZosVersion: fmt.Sprintf("3.%d", nodes)})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(nodes), nodeID)
+	assert.Len(t, rmbClient.zosVersionCalls, nodes)
+	assert.Greater(t, rmbClient.maxZosVersionCalls, 1, "the nodes are asked concurrently")
+	assert.LessOrEqual(t, rmbClient.maxZosVersionCalls, zosVersionWorkers)
+}
@@ -0,0 +1,256 @@
+// Package state provides a state to save the user work in a database.
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+)
+
+const (
+	// FileBackend stores the state in a local file
+	FileBackend = "file"
+	// HTTPBackend stores the state behind an http endpoint
+	HTTPBackend = "http"
+	// RedisBackend stores the state in a redis key
+	RedisBackend = "redis"
+
+	// lockRetryInterval is how long to wait before retrying to acquire a held lock
+	lockRetryInterval = time.Second
+	// staleLockAge is the age of a lock left behind by a crashed run, a lock is only held while the state is changed
+	staleLockAge = 5 * time.Minute
+)
+
+// Backend is a Getter whose state is stored somewhere that can be shared between terraform runs.
+// The state is locked while it's reloaded, changed and saved, so that concurrent runs can't write it at the same time.
+type Backend interface {
+	Getter
+	// Lock acquires the state lock, waiting for it to be released until the context is done
+	Lock(ctx context.Context) error
+	// Unlock releases the state lock
+	Unlock(ctx context.Context) error
+	// Load loads the state
+	Load(ctx context.Context) error
+	// Save saves the state
+	Save(ctx context.Context) error
+}
+
+// Config is the configuration of a state backend
+type Config struct {
+	// Backend is one of file, http or redis
+	Backend string
+	// Path is the state file path of the file backend
+	Path string
+	// URL is the address of the http backend, or the redis url of the redis backend
+	URL string
+	// Key is the redis key of the redis backend
+	Key string
+}
+
+// NewBackend creates the state backend described by the configuration
+func NewBackend(cfg Config) (Backend, error) {
+	switch cfg.Backend {
+	case "", FileBackend:
+		path := cfg.Path
+		if path == "" {
+			path = FileName
+		}
+		backend := NewLocalFileState(path)
+		return &backend, nil
+	case HTTPBackend:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("the http state backend needs a url")
+		}
+		return NewHTTPState(cfg.URL), nil
+	case RedisBackend:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("the redis state backend needs a url")
+		}
+		return NewRedisState(cfg.URL, cfg.Key)
+	default:
+		return nil, fmt.Errorf("unknown state backend '%s', expected one of: %s %s %s", cfg.Backend, FileBackend, HTTPBackend, RedisBackend)
+	}
+}
+
+// LockInfo describes the holder of a state lock
+type LockInfo struct {
+	ID      string    `json:"ID"`
+	Who     string    `json:"Who"`
+	Created time.Time `json:"Created"`
+}
+
+func newLockInfo() LockInfo {
+	host, _ := os.Hostname()
+	return LockInfo{
+		ID:      uuid.New().String(),
+		Who:     fmt.Sprintf("%s (pid %d)", host, os.Getpid()),
+		Created: time.Now().UTC(),
+	}
+}
+
+// retryLock calls tryLock until it acquires the lock, fails, or the context is done.
+func retryLock(ctx context.Context, tryLock func() (bool, error)) error {
+	for {
+		locked, err := tryLock()
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "timed out waiting for the state lock")
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Store holds the state of the backend configured by the provider.
+// Each change of the state is a read-modify-write under the backend lock: the state is reloaded, changed and saved
+// while holding the lock, so that providers sharing the backend (e.g. aliased provider blocks, each running in its
+// own process) see each other's allocations and don't overwrite each other's changes.
+type Store struct {
+	// mu serializes the changes of the state
+	mu          sync.Mutex
+	backend     Backend
+	lockTimeout time.Duration
+	// networks is the network state shared with the deployers, it's refreshed in place each time the backend is reloaded
+	networks state.NetworkState
+}
+
+// NewStore creates a store with no backend, its state is empty until a backend is opened
+func NewStore() *Store {
+	return &Store{}
+}
+
+// Open loads the state of the backend, waiting up to lockTimeout for its lock each time the state is changed
+func (s *Store) Open(ctx context.Context, backend Backend, lockTimeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.backend != nil {
+		return fmt.Errorf("state backend is already opened")
+	}
+
+	s.lockTimeout = lockTimeout
+	if err := s.withLock(ctx, backend, backend.Load); err != nil {
+		return errors.Wrap(err, "failed to load state")
+	}
+
+	s.backend = backend
+	st := backend.GetState()
+	s.refresh(st.GetNetworkState())
+	return nil
+}
+
+// withLock runs fn while holding the backend lock
+func (s *Store) withLock(ctx context.Context, backend Backend, fn func(ctx context.Context) error) error {
+	lockCtx, cancel := context.WithTimeout(ctx, s.lockTimeout)
+	defer cancel()
+	if err := backend.Lock(lockCtx); err != nil {
+		return errors.Wrap(err, "failed to lock state")
+	}
+
+	err := fn(ctx)
+	if unlockErr := backend.Unlock(ctx); unlockErr != nil {
+		if err == nil {
+			return errors.Wrap(unlockErr, "failed to unlock state")
+		}
+		log.Printf("failed to unlock state: %s", unlockErr)
+	}
+	return err
+}
+
+// GetState returns the state shared with the deployers
+func (s *Store) GetState() State {
+	if s.networks == nil {
+		s.networks = make(state.NetworkState)
+	}
+	return State{Networks: s.networks}
+}
+
+// Update changes the state with fn: the state is reloaded from the backend, changed and saved while holding the
+// backend lock, then the state shared with the deployers is refreshed.
+func (s *Store) Update(ctx context.Context, fn func(networks state.NetworkState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(ctx, fn)
+}
+
+// Networks returns a copy of the network state, for an operation to change it on its own before its changes are
+// saved with Update
+func (s *Store) Networks() state.NetworkState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CopyNetworks(s.GetState().Networks)
+}
+
+// Close waits for the running change, then closes the opened backend.
+// The changes are saved as they're made, so nothing is left to save.
+func (s *Store) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backend = nil
+	return nil
+}
+
+func (s *Store) update(ctx context.Context, fn func(networks state.NetworkState) error) error {
+	if s.backend == nil {
+		return fn(s.GetState().Networks)
+	}
+
+	return s.withLock(ctx, s.backend, func(ctx context.Context) error {
+		if err := s.backend.Load(ctx); err != nil {
+			return errors.Wrap(err, "failed to reload state")
+		}
+		st := s.backend.GetState()
+		networks := st.GetNetworkState()
+		before, err := json.Marshal(networks)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode state")
+		}
+
+		if err := fn(networks); err != nil {
+			return err
+		}
+
+		after, err := json.Marshal(networks)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode state")
+		}
+		if !bytes.Equal(before, after) {
+			if err := s.backend.Save(ctx); err != nil {
+				return errors.Wrap(err, "failed to save state")
+			}
+		}
+		s.refresh(networks)
+		return nil
+	})
+}
+
+// refresh replaces the content of the state shared with the deployers with a copy of networks
+func (s *Store) refresh(networks state.NetworkState) {
+	if s.networks == nil {
+		s.networks = make(state.NetworkState)
+	}
+	for name := range s.networks {
+		delete(s.networks, name)
+	}
+	for name, network := range CopyNetworks(networks) {
+		s.networks[name] = network
+	}
+}
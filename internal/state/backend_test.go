@@ -0,0 +1,229 @@
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+)
+
+func TestNewBackend(t *testing.T) {
+	cases := []struct {
+		cfg Config
+		err bool
+	}{
+		{Config{}, false},
+		{Config{Backend: FileBackend, Path: "state.json"}, false},
+		{Config{Backend: HTTPBackend, URL: "http://localhost/state"}, false},
+		{Config{Backend: HTTPBackend}, true},
+		{Config{Backend: RedisBackend, URL: "redis://localhost:6379/0"}, false},
+		{Config{Backend: RedisBackend, URL: "localhost"}, true},
+		{Config{Backend: "s3"}, true},
+	}
+	for _, c := range cases {
+		_, err := NewBackend(c.cfg)
+		assert.Equal(t, c.err, err != nil, "config %+v", c.cfg)
+	}
+}
+
+func TestStoreLocksOnlyWhileChanging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	ctx := context.Background()
+
+	// aliased provider blocks sharing the same state each open their own store
+	first, second := NewStore(), NewStore()
+	firstBackend, secondBackend := NewLocalFileState(path), NewLocalFileState(path)
+	require.NoError(t, first.Open(ctx, &firstBackend, 50*time.Millisecond))
+	require.NoError(t, second.Open(ctx, &secondBackend, 50*time.Millisecond), "the first store doesn't hold the lock")
+	assert.NoFileExists(t, path+".lock")
+
+	require.NoError(t, first.Update(ctx, func(networks state.NetworkState) error {
+		network := networks.GetNetwork("net1")
+		network.SetNodeSubnet(1, "10.1.2.0/24")
+		return nil
+	}))
+	assert.NoFileExists(t, path+".lock")
+	network := first.GetState().Networks.GetNetwork("net1")
+	assert.Equal(t, "10.1.2.0/24", network.GetNodeSubnet(1), "the shared state is refreshed")
+
+	require.NoError(t, first.Close(ctx))
+	require.NoError(t, second.Close(ctx))
+	assert.NoFileExists(t, path+".lock")
+}
+
+func TestStoreLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	holder := NewLocalFileState(path)
+	require.NoError(t, holder.Lock(context.Background()))
+
+	backend := NewLocalFileState(path)
+	err := NewStore().Open(context.Background(), &backend, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "is locked by")
+}
+
+// countingBackend is an in-memory backend counting its saves
+type countingBackend struct {
+	st    State
+	saves int
+}
+
+func (b *countingBackend) GetState() State {
+	if b.st.Networks == nil {
+		b.st = NewState()
+	}
+	return b.st
+}
+
+func (b *countingBackend) Lock(ctx context.Context) error   { return nil }
+func (b *countingBackend) Unlock(ctx context.Context) error { return nil }
+func (b *countingBackend) Load(ctx context.Context) error   { return nil }
+
+func (b *countingBackend) Save(ctx context.Context) error {
+	b.saves++
+	return nil
+}
+
+func TestStoreSavesChanges(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingBackend{}
+	st := NewStore()
+	require.NoError(t, st.Open(ctx, backend, time.Second))
+
+	require.NoError(t, st.Update(ctx, func(networks state.NetworkState) error { return nil }))
+	assert.Equal(t, 0, backend.saves, "an unchanged state isn't written")
+
+	setSubnet := func(networks state.NetworkState) error {
+		network := networks.GetNetwork("net1")
+		network.SetNodeSubnet(1, "10.1.2.0/24")
+		return nil
+	}
+	require.NoError(t, st.Update(ctx, setSubnet))
+	require.NoError(t, st.Update(ctx, setSubnet))
+	assert.Equal(t, 1, backend.saves, "the state is written once per change")
+
+	require.NoError(t, st.Close(ctx))
+	assert.Equal(t, 1, backend.saves)
+}
+
+func TestStoreConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	backend := NewLocalFileState(filepath.Join(t.TempDir(), FileName))
+	st := NewStore()
+	require.NoError(t, st.Open(ctx, &backend, time.Second))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the changes of parallel operations are serialized
+			err := st.Update(ctx, func(networks state.NetworkState) error {
+				network := networks.GetNetwork("net1")
+				for j := 0; j < 100; j++ {
+					network.SetDeploymentHostIDs(uint32(i), uint64(j), []byte{byte(j)})
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, st.Close(ctx))
+
+	loaded := NewLocalFileState(backend.path)
+	require.NoError(t, loaded.Load(ctx))
+	network := loaded.GetState().Networks.GetNetwork("net1")
+	assert.Len(t, network.NodeDeploymentHostIDs, 20)
+}
+
+func TestStoresSharingBackendAllocateDifferentHostIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	ctx := context.Background()
+
+	// aliased provider blocks sharing the same state each open their own store
+	stores := []*Store{NewStore(), NewStore()}
+	for _, st := range stores {
+		backend := NewLocalFileState(path)
+		require.NoError(t, st.Open(ctx, &backend, 10*time.Second))
+	}
+
+	var wg sync.WaitGroup
+	for i, st := range stores {
+		wg.Add(1)
+		go func(i int, st *Store) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// allocate the first free host id, like the deployers do
+				err := st.Update(ctx, func(networks state.NetworkState) error {
+					network := networks.GetNetwork("net1")
+					used := network.GetUsedNetworkHostIDs(1)
+					hostID := byte(2)
+					for contains(used, hostID) {
+						hostID++
+					}
+					network.SetDeploymentHostIDs(1, uint64(i*100+j), []byte{hostID})
+					return nil
+				})
+				assert.NoError(t, err)
+			}
+		}(i, st)
+	}
+	wg.Wait()
+
+	loaded := NewLocalFileState(path)
+	require.NoError(t, loaded.Load(ctx))
+	network := loaded.GetState().Networks.GetNetwork("net1")
+	assert.Len(t, network.NodeDeploymentHostIDs[1], 20, "no store overwrote the allocations of the other")
+	hostIDs := network.GetUsedNetworkHostIDs(1)
+	seen := map[byte]bool{}
+	for _, id := range hostIDs {
+		assert.False(t, seen[id], "host id %d is allocated twice", id)
+		seen[id] = true
+	}
+}
+
+func TestStoreApplyChangesKeepsOtherStoresChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	ctx := context.Background()
+
+	first, second := NewStore(), NewStore()
+	firstBackend, secondBackend := NewLocalFileState(path), NewLocalFileState(path)
+	require.NoError(t, first.Open(ctx, &firstBackend, time.Second))
+	require.NoError(t, second.Open(ctx, &secondBackend, time.Second))
+
+	require.NoError(t, second.Update(ctx, func(networks state.NetworkState) error {
+		network := networks.GetNetwork("net1")
+		network.SetDeploymentHostIDs(1, 20, []byte{2})
+		return nil
+	}))
+	// the operation of the first store started before the change of the second one
+	base := first.Networks()
+	changed := CopyNetworks(base)
+	network := changed.GetNetwork("net1")
+	network.SetDeploymentHostIDs(1, 10, []byte{3})
+	require.NoError(t, first.Update(ctx, func(networks state.NetworkState) error {
+		ApplyChanges(networks, base, changed)
+		return nil
+	}))
+
+	loaded := NewLocalFileState(path)
+	require.NoError(t, loaded.Load(ctx))
+	network = loaded.GetState().Networks.GetNetwork("net1")
+	assert.Equal(t, map[uint64][]byte{10: {3}, 20: {2}}, map[uint64][]byte(network.NodeDeploymentHostIDs[1]))
+	network = first.Networks().GetNetwork("net1")
+	assert.Equal(t, []byte{2}, network.GetDeploymentHostIDs(1, 20), "the state of the store is refreshed")
+}
+
+func contains(ids []byte, id byte) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
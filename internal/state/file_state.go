@@ -2,9 +2,15 @@
 package state
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"reflect"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -22,37 +28,153 @@ const (
 
 // LocalFileState struct is the local state file
 type LocalFileState struct {
-	st State
+	path string
+	st   State
 }
 
-// NewLocalFileState generates a new local state
-func NewLocalFileState() LocalFileState {
-	return LocalFileState{}
+// NewLocalFileState generates a new local state stored at the given path
+func NewLocalFileState(path string) LocalFileState {
+	return LocalFileState{path: path}
+}
+
+// lockPath is the path of the lock file created beside the state file
+func (f *LocalFileState) lockPath() string {
+	return f.path + ".lock"
+}
+
+// Lock creates the lock file, it fails to be created as long as another run holds it.
+// A lock older than staleLockAge was left behind by a crashed run and is removed.
+func (f *LocalFileState) Lock(ctx context.Context) error {
+	info, err := json.Marshal(newLockInfo())
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lock info")
+	}
+
+	create := func() (bool, error) {
+		lock, err := os.OpenFile(f.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to create lock file: %s", f.lockPath())
+		}
+		defer lock.Close()
+
+		if _, err := lock.Write(info); err != nil {
+			return false, errors.Wrapf(err, "failed to write lock file: %s", f.lockPath())
+		}
+		return true, nil
+	}
+
+	var holder LockInfo
+	err = retryLock(ctx, func() (bool, error) {
+		locked, err := create()
+		if locked || err != nil {
+			return locked, err
+		}
+
+		holder, err = f.lockHolder()
+		if err != nil {
+			return false, err
+		}
+		if time.Since(holder.Created) <= staleLockAge {
+			return false, nil
+		}
+		log.Printf("removing stale lock file %s held by %s since %s", f.lockPath(), holder.Who, holder.Created)
+		if err := f.Unlock(ctx); err != nil {
+			return false, err
+		}
+		return create()
+	})
+	return errors.Wrapf(err, "state file %s is locked by %s since %s, if no other run is using it remove %s", f.path, holder.Who, holder.Created, f.lockPath())
+}
+
+// lockHolder reads the lock file, a lock file that can't be parsed (e.g. being written) is dated by its modification time
+func (f *LocalFileState) lockHolder() (LockInfo, error) {
+	var holder LockInfo
+	content, err := os.ReadFile(f.lockPath())
+	if os.IsNotExist(err) {
+		// released in the meantime
+		return LockInfo{Created: time.Now()}, nil
+	}
+	if err != nil {
+		return holder, errors.Wrapf(err, "failed to read lock file: %s", f.lockPath())
+	}
+	if err := json.Unmarshal(content, &holder); err == nil && !holder.Created.IsZero() {
+		return holder, nil
+	}
+
+	stat, err := os.Stat(f.lockPath())
+	if os.IsNotExist(err) {
+		return LockInfo{Created: time.Now()}, nil
+	}
+	if err != nil {
+		return holder, errors.Wrapf(err, "failed to read lock file: %s", f.lockPath())
+	}
+	return LockInfo{Who: "unknown", Created: stat.ModTime()}, nil
+}
 
+// Unlock removes the lock file
+func (f *LocalFileState) Unlock(ctx context.Context) error {
+	err := os.Remove(f.lockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove lock file: %s, it has to be removed manually", f.lockPath())
+	}
+	return nil
 }
 
-// Load loads state from state.json file
-func (f *LocalFileState) Load(FileName string) error {
-	// os.OpenFile(FileName, os.O_CREATE, 0644)
+// Load loads state from the state file.
+// If the file is corrupted, e.g. by a run killed mid-write before writes were atomic, the state is recovered from the backup
+// and the corrupted file is kept aside for inspection.
+func (f *LocalFileState) Load(ctx context.Context) error {
 	f.st = State{}
-	_, err := os.Stat(FileName)
+	_, err := os.Stat(f.path)
 	if err != nil && os.IsNotExist(err) {
-		_, err = os.OpenFile(FileName, os.O_CREATE, 0644)
+		_, err = os.OpenFile(f.path, os.O_CREATE, 0644)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
-	content, err := os.ReadFile(FileName)
+
+	st, err := readStateFile(f.path)
+	if err == nil {
+		f.st = st
+		return nil
+	}
+
+	backup, backupErr := readStateFile(f.backupPath())
+	if backupErr != nil {
+		return errors.Wrapf(err, "failed to load state file %s and its backup %s (%s)", f.path, f.backupPath(), backupErr)
+	}
+
+	corruptedPath := fmt.Sprintf("%s.corrupted.%d", f.path, time.Now().Unix())
+	if err := os.Rename(f.path, corruptedPath); err != nil {
+		return errors.Wrapf(err, "failed to move corrupted state file %s aside", f.path)
+	}
+	log.Printf("state file %s is corrupted (%s), recovered the state from %s and moved the corrupted file to %s", f.path, err, f.backupPath(), corruptedPath)
+
+	f.st = backup
+	return nil
+}
+
+// readStateFile reads and decodes a state file, an empty file is an empty state
+func readStateFile(path string) (State, error) {
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return State{}, err
 	}
 
-	err = json.Unmarshal(content, &f.st)
+	var st State
+	if len(bytes.TrimSpace(content)) == 0 {
+		return st, nil
+	}
+
+	err = json.Unmarshal(content, &st)
 	if err != nil {
-		return err
+		return State{}, errors.Wrapf(err, "failed to parse state file %s", path)
 	}
-	return nil
+	return st, nil
 }
 
 // GetState returns the current state
@@ -64,20 +186,64 @@ func (f *LocalFileState) GetState() State {
 	return f.st
 }
 
-// Save saves the state to the state,json file
-func (f *LocalFileState) Save(FileName string) error {
-	if content, err := json.Marshal(f.st); err == nil {
-		err = os.WriteFile(FileName, content, 0644)
+// Save saves the state to the state file.
+// The state is written to a temporary file that replaces the state file, so a killed run can't leave a partially written state.
+// The previous state is kept as a backup to recover from.
+func (f *LocalFileState) Save(ctx context.Context) error {
+	content, err := json.Marshal(f.st)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save file: %s", f.path)
+	}
+
+	// a corrupted state file doesn't replace a good backup
+	if previous, err := readStateFile(f.path); err == nil {
+		previousContent, err := json.Marshal(previous)
 		if err != nil {
-			return errors.Wrapf(err, "failed to write file: %s", FileName)
+			return errors.Wrapf(err, "failed to encode backup of file: %s", f.path)
 		}
-	} else {
-		return errors.Wrapf(err, "failed to save file: %s", FileName)
+		if err := writeFileAtomic(f.backupPath(), previousContent); err != nil {
+			return errors.Wrapf(err, "failed to write backup file: %s", f.backupPath())
+		}
+	}
+
+	if err := writeFileAtomic(f.path, content); err != nil {
+		return errors.Wrapf(err, "failed to write file: %s", f.path)
 	}
 	return nil
 }
 
-// Delete deletes state,json file
-func (f *LocalFileState) Delete(FileName string) error {
-	return os.Remove(FileName)
+// writeFileAtomic writes the content to a temporary file in the same directory, then renames it over the path
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// backupPath is the path of the previous state, created beside the state file
+func (f *LocalFileState) backupPath() string {
+	return f.path + ".backup"
+}
+
+// Delete deletes the state file
+func (f *LocalFileState) Delete() error {
+	return os.Remove(f.path)
 }
@@ -0,0 +1,134 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFileStateLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	first, second := NewLocalFileState(path), NewLocalFileState(path)
+
+	require.NoError(t, first.Lock(context.Background()))
+
+	var holder LockInfo
+	content, err := os.ReadFile(path + ".lock")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(content, &holder))
+	assert.Contains(t, holder.Who, "pid")
+	assert.WithinDuration(t, time.Now(), holder.Created, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = second.Lock(ctx)
+	assert.ErrorContains(t, err, "is locked by "+holder.Who)
+
+	require.NoError(t, first.Unlock(context.Background()))
+	assert.NoError(t, second.Lock(context.Background()), "the lock is released")
+	assert.NoError(t, second.Unlock(context.Background()))
+	assert.NoFileExists(t, path+".lock")
+}
+
+func TestLocalFileStateStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	stale, err := json.Marshal(LockInfo{ID: "id", Who: "crashed", Created: time.Now().Add(-2 * staleLockAge)})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+".lock", stale, 0644))
+
+	f := NewLocalFileState(path)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, f.Lock(ctx), "a stale lock is removed")
+
+	content, err := os.ReadFile(path + ".lock")
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "crashed")
+}
+
+func TestLocalFileStateSaveAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	ctx := context.Background()
+
+	f := NewLocalFileState(path)
+	require.NoError(t, f.Load(ctx))
+	network := f.GetState().Networks.GetNetwork("net1")
+	network.SetNodeSubnet(1, "10.1.2.0/24")
+	require.NoError(t, f.Save(ctx))
+	first, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	network.SetNodeSubnet(2, "10.1.3.0/24")
+	require.NoError(t, f.Save(ctx))
+
+	backup, err := os.ReadFile(path + ".backup")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(first), string(backup), "the previous state is kept as a backup")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{FileName, FileName + ".backup"}, names, "no temporary file is left behind")
+
+	loaded := NewLocalFileState(path)
+	require.NoError(t, loaded.Load(ctx))
+	network = loaded.GetState().Networks.GetNetwork("net1")
+	assert.Equal(t, "10.1.3.0/24", network.GetNodeSubnet(2))
+}
+
+func TestLocalFileStateRecoverCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	ctx := context.Background()
+
+	f := NewLocalFileState(path)
+	require.NoError(t, f.Load(ctx))
+	network := f.GetState().Networks.GetNetwork("net1")
+	network.SetNodeSubnet(1, "10.1.2.0/24")
+	require.NoError(t, f.Save(ctx))
+	network.SetNodeSubnet(2, "10.1.3.0/24")
+	require.NoError(t, f.Save(ctx))
+
+	// a run killed mid-write before writes were atomic
+	require.NoError(t, os.WriteFile(path, []byte(`{"networks": {"net1"`), 0644))
+
+	recovered := NewLocalFileState(path)
+	require.NoError(t, recovered.Load(ctx))
+	network = recovered.GetState().Networks.GetNetwork("net1")
+	assert.Equal(t, "10.1.2.0/24", network.GetNodeSubnet(1), "the state is recovered from the backup")
+
+	corrupted, err := filepath.Glob(path + ".corrupted.*")
+	require.NoError(t, err)
+	require.Len(t, corrupted, 1, "the corrupted file is moved aside")
+	content, err := os.ReadFile(corrupted[0])
+	require.NoError(t, err)
+	assert.Equal(t, `{"networks": {"net1"`, string(content))
+
+	// the corrupted file doesn't replace the backup
+	require.NoError(t, os.WriteFile(path, []byte("corrupted"), 0644))
+	require.NoError(t, recovered.Save(ctx))
+	backup, err := os.ReadFile(path + ".backup")
+	require.NoError(t, err)
+	assert.Contains(t, string(backup), "10.1.2.0/24")
+}
+
+func TestLocalFileStateCorruptedWithoutBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	require.NoError(t, os.WriteFile(path, []byte("corrupted"), 0644))
+
+	f := NewLocalFileState(path)
+	assert.ErrorContains(t, f.Load(context.Background()), "failed to load state file")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "corrupted", string(content), "the corrupted file is kept")
+}
@@ -0,0 +1,179 @@
+// Package state provides a state to save the user work in a database.
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPState stores the state behind an http endpoint, following the protocol of terraform's http backend:
+// the state is read with GET and written with POST, and locked and unlocked with the LOCK and UNLOCK methods on the same address.
+// A held lock is reported with 409 Conflict or 423 Locked.
+type HTTPState struct {
+	url    string
+	client *http.Client
+	lock   *LockInfo
+	st     State
+}
+
+// NewHTTPState generates a new http state stored at the given url
+func NewHTTPState(address string) *HTTPState {
+	return &HTTPState{
+		url:    address,
+		client: http.DefaultClient,
+	}
+}
+
+func (h *HTTPState) do(ctx context.Context, method, address string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s request", method)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to send %s request", method)
+	}
+	return resp, nil
+}
+
+func httpStatusError(resp *http.Response) error {
+	content, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("%s request to %s failed with status %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, content)
+}
+
+// Lock locks the state with the LOCK method
+func (h *HTTPState) Lock(ctx context.Context) error {
+	info := newLockInfo()
+	body, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lock info")
+	}
+
+	err = retryLock(ctx, func() (bool, error) {
+		resp, err := h.do(ctx, "LOCK", h.url, body)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusConflict, http.StatusLocked:
+			return false, nil
+		default:
+			return false, httpStatusError(resp)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	h.lock = &info
+	return nil
+}
+
+// Unlock unlocks the state with the UNLOCK method
+func (h *HTTPState) Unlock(ctx context.Context) error {
+	if h.lock == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(h.lock)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lock info")
+	}
+
+	resp, err := h.do(ctx, "UNLOCK", h.url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpStatusError(resp)
+	}
+
+	h.lock = nil
+	return nil
+}
+
+// Load loads the state with the GET method, a missing state is an empty one
+func (h *HTTPState) Load(ctx context.Context) error {
+	h.st = State{}
+
+	resp, err := h.do(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return httpStatusError(resp)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read state")
+	}
+	if len(content) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(content, &h.st)
+}
+
+// GetState returns the current state
+func (h *HTTPState) GetState() State {
+	if h.st.Networks == nil {
+		h.st = NewState()
+	}
+	return h.st
+}
+
+// Save saves the state with the POST method
+func (h *HTTPState) Save(ctx context.Context) error {
+	content, err := json.Marshal(h.st)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode state")
+	}
+
+	// the lock id is passed along the state like terraform does, so the server can check who's writing
+	address := h.url
+	if h.lock != nil {
+		u, err := url.Parse(h.url)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse url")
+		}
+		query := u.Query()
+		query.Set("ID", h.lock.ID)
+		u.RawQuery = query.Encode()
+		address = u.String()
+	}
+
+	resp, err := h.do(ctx, http.MethodPost, address, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return httpStatusError(resp)
+	}
+	return nil
+}
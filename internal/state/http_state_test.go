@@ -0,0 +1,102 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// httpStateServer implements terraform's http backend protocol in memory
+type httpStateServer struct {
+	mu    sync.Mutex
+	state []byte
+	lock  *LockInfo
+	// writers are the lock ids passed along the saved states
+	writers []string
+}
+
+func (s *httpStateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	switch r.Method {
+	case "LOCK":
+		if s.lock != nil {
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		var info LockInfo
+		if err := json.Unmarshal(body, &info); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.lock = &info
+	case "UNLOCK":
+		var info LockInfo
+		if err := json.Unmarshal(body, &info); err != nil || s.lock == nil || s.lock.ID != info.ID {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.lock = nil
+	case http.MethodGet:
+		if s.state == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write(s.state)
+	case http.MethodPost:
+		s.state = body
+		s.writers = append(s.writers, r.URL.Query().Get("ID"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPState(t *testing.T) {
+	server := &httpStateServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	h := NewHTTPState(ts.URL)
+	require.NoError(t, h.Load(context.Background()), "a missing state is empty")
+	assert.Empty(t, h.GetState().Networks)
+
+	require.NoError(t, h.Lock(context.Background()))
+	lockID := h.lock.ID
+
+	other := NewHTTPState(ts.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, other.Lock(ctx), "the state is locked")
+
+	network := h.GetState().Networks.GetNetwork("net1")
+	network.SetNodeSubnet(1, "10.1.2.0/24")
+	require.NoError(t, h.Save(context.Background()))
+	require.NoError(t, h.Unlock(context.Background()))
+	assert.Equal(t, []string{lockID}, server.writers, "the lock id is passed along the state")
+
+	require.NoError(t, other.Load(context.Background()))
+	network = other.GetState().Networks.GetNetwork("net1")
+	assert.Equal(t, "10.1.2.0/24", network.GetNodeSubnet(1))
+	assert.NoError(t, other.Lock(context.Background()), "the lock is released")
+}
+
+func TestHTTPStateErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	h := NewHTTPState(ts.URL)
+	assert.ErrorContains(t, h.Load(context.Background()), "500")
+	assert.ErrorContains(t, h.Lock(context.Background()), "500", "a failing server isn't retried like a held lock")
+}
@@ -0,0 +1,161 @@
+// Package state provides a state to save the user work in a database.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultRedisKey is the key the state is stored at if none is configured
+	DefaultRedisKey = "tfgrid:state"
+
+	// redisLockTTL is the lock expiry, the lock is refreshed while held so a crashed run can't keep it forever
+	redisLockTTL = time.Minute
+)
+
+// scripts that only touch the lock if it's still held by us
+var (
+	redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	redisRefreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// RedisState stores the state in a redis key, the lock is stored at the same key suffixed with :lock
+type RedisState struct {
+	client *redis.Client
+	key    string
+	lockID string
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	st     State
+}
+
+// NewRedisState generates a new redis state stored at the given key of the redis url (e.g. redis://:password@host:6379/0)
+func NewRedisState(url, key string) (*RedisState, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse redis url")
+	}
+
+	if key == "" {
+		key = DefaultRedisKey
+	}
+
+	return &RedisState{
+		client: redis.NewClient(opts),
+		key:    key,
+	}, nil
+}
+
+func (r *RedisState) lockKey() string {
+	return r.key + ":lock"
+}
+
+// Lock sets the lock key if it doesn't exist, and keeps refreshing its expiry until unlocked
+func (r *RedisState) Lock(ctx context.Context) error {
+	info := newLockInfo()
+	client := r.client.WithContext(ctx)
+
+	err := retryLock(ctx, func() (bool, error) {
+		locked, err := client.SetNX(r.lockKey(), info.ID, redisLockTTL).Result()
+		if err != nil {
+			return false, errors.Wrap(err, "failed to set lock key")
+		}
+		return locked, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.lockID = info.ID
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+	go r.refreshLock(r.lockID, r.stop)
+	return nil
+}
+
+func (r *RedisState) refreshLock(lockID string, stop chan struct{}) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(redisLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := redisRefreshScript.Run(r.client, []string{r.lockKey()}, lockID, redisLockTTL.Milliseconds()).Err()
+			if err != nil {
+				log.Printf("failed to refresh state lock: %s", err)
+			}
+		}
+	}
+}
+
+// Unlock deletes the lock key if it's still ours
+func (r *RedisState) Unlock(ctx context.Context) error {
+	if r.lockID == "" {
+		return nil
+	}
+
+	close(r.stop)
+	r.wg.Wait()
+
+	err := redisUnlockScript.Run(r.client.WithContext(ctx), []string{r.lockKey()}, r.lockID).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to delete lock key")
+	}
+
+	r.lockID = ""
+	return nil
+}
+
+// Load loads the state from the key, a missing key is an empty state
+func (r *RedisState) Load(ctx context.Context) error {
+	r.st = State{}
+
+	content, err := r.client.WithContext(ctx).Get(r.key).Bytes()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get key %s", r.key)
+	}
+
+	return json.Unmarshal(content, &r.st)
+}
+
+// GetState returns the current state
+func (r *RedisState) GetState() State {
+	if r.st.Networks == nil {
+		r.st = NewState()
+	}
+	return r.st
+}
+
+// Save saves the state to the key
+func (r *RedisState) Save(ctx context.Context) error {
+	content, err := json.Marshal(r.st)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode state")
+	}
+
+	if err := r.client.WithContext(ctx).Set(r.key, content, 0).Err(); err != nil {
+		return errors.Wrapf(err, "failed to set key %s", r.key)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package state
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis serves the few commands used by the redis state over the redis protocol,
+// the lock scripts are recognized by the command they run
+type fakeRedis struct {
+	mu   sync.Mutex
+	keys map[string]string
+	ln   net.Listener
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &fakeRedis{keys: map[string]string{}, ln: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) url() string {
+	return "redis://" + r.ln.Addr().String() + "/0"
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, r.handle(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func (r *fakeRedis) handle(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bulk := func(value string, ok bool) string {
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "get":
+		value, ok := r.keys[args[1]]
+		return bulk(value, ok)
+	case "set":
+		nx := strings.EqualFold(args[len(args)-1], "nx")
+		if _, ok := r.keys[args[1]]; ok && nx {
+			return bulk("", false)
+		}
+		r.keys[args[1]] = args[2]
+		return "+OK\r\n"
+	case "evalsha":
+		return "-NOSCRIPT No matching script\r\n"
+	case "eval":
+		script, key, lockID := args[1], args[3], args[4]
+		if r.keys[key] != lockID {
+			return ":0\r\n"
+		}
+		if strings.Contains(script, "DEL") {
+			delete(r.keys, key)
+		}
+		return ":1\r\n"
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func TestRedisState(t *testing.T) {
+	server := newFakeRedis(t)
+
+	r, err := NewRedisState(server.url(), "")
+	require.NoError(t, err)
+	require.NoError(t, r.Load(context.Background()), "a missing key is an empty state")
+	assert.Empty(t, r.GetState().Networks)
+
+	require.NoError(t, r.Lock(context.Background()))
+	assert.Equal(t, r.lockID, server.keys[DefaultRedisKey+":lock"])
+
+	other, err := NewRedisState(server.url(), "")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, other.Lock(ctx), "the state is locked")
+
+	network := r.GetState().Networks.GetNetwork("net1")
+	network.SetNodeSubnet(1, "10.1.2.0/24")
+	require.NoError(t, r.Save(context.Background()))
+	require.NoError(t, r.Unlock(context.Background()))
+	assert.NotContains(t, server.keys, DefaultRedisKey+":lock")
+
+	require.NoError(t, other.Load(context.Background()))
+	network = other.GetState().Networks.GetNetwork("net1")
+	assert.Equal(t, "10.1.2.0/24", network.GetNodeSubnet(1))
+	assert.NoError(t, other.Lock(context.Background()), "the lock is released")
+	assert.NoError(t, other.Unlock(context.Background()))
+}
+
+func TestRedisStateUnlockOthersLock(t *testing.T) {
+	server := newFakeRedis(t)
+	r, err := NewRedisState(server.url(), "key")
+	require.NoError(t, err)
+	require.NoError(t, r.Lock(context.Background()))
+
+	// the lock expired and was taken by another run
+	server.keys["key:lock"] = "other"
+	require.NoError(t, r.Unlock(context.Background()))
+	assert.Equal(t, "other", server.keys["key:lock"], "only our own lock is deleted")
+}
@@ -1,7 +1,11 @@
 // Package state provides a state to save the user work in a database.
 package state
 
-import "github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+import (
+	"bytes"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+)
 
 // State struct
 type State struct {
@@ -22,3 +26,68 @@ func NewState() State {
 		Networks: make(state.NetworkState),
 	}
 }
+
+// CopyNetworks returns a deep copy of a network state
+func CopyNetworks(networks state.NetworkState) state.NetworkState {
+	res := make(state.NetworkState, len(networks))
+	for name, network := range networks {
+		cp := state.NewNetwork()
+		for nodeID, subnet := range network.Subnets {
+			cp.SetNodeSubnet(nodeID, subnet)
+		}
+		for nodeID, deployments := range network.NodeDeploymentHostIDs {
+			cp.NodeDeploymentHostIDs[nodeID] = state.DeploymentHostIDs{}
+			for contractID, hostIDs := range deployments {
+				cp.SetDeploymentHostIDs(nodeID, contractID, append([]byte{}, hostIDs...))
+			}
+		}
+		res[name] = cp
+	}
+	return res
+}
+
+// ApplyChanges applies to networks the changes turning base into changed, the other entries of networks are kept.
+// It's used to save the changes of an operation on top of a state changed by others since the operation started.
+func ApplyChanges(networks, base, changed state.NetworkState) {
+	for name := range base {
+		if _, ok := changed[name]; !ok {
+			networks.DeleteNetwork(name)
+		}
+	}
+
+	for name, network := range changed {
+		old := base[name]
+		current := networks.GetNetwork(name)
+		if current.Subnets == nil || current.NodeDeploymentHostIDs == nil {
+			current = CopyNetworks(state.NetworkState{name: current})[name]
+			networks[name] = current
+		}
+
+		for nodeID, subnet := range network.Subnets {
+			if oldSubnet, ok := old.Subnets[nodeID]; !ok || oldSubnet != subnet {
+				current.SetNodeSubnet(nodeID, subnet)
+			}
+		}
+		for nodeID := range old.Subnets {
+			if _, ok := network.Subnets[nodeID]; !ok {
+				delete(current.Subnets, nodeID)
+			}
+		}
+
+		for nodeID, deployments := range network.NodeDeploymentHostIDs {
+			for contractID, hostIDs := range deployments {
+				oldHostIDs, ok := old.NodeDeploymentHostIDs[nodeID][contractID]
+				if !ok || !bytes.Equal(oldHostIDs, hostIDs) {
+					current.SetDeploymentHostIDs(nodeID, contractID, append([]byte{}, hostIDs...))
+				}
+			}
+		}
+		for nodeID, deployments := range old.NodeDeploymentHostIDs {
+			for contractID := range deployments {
+				if _, ok := network.NodeDeploymentHostIDs[nodeID][contractID]; !ok {
+					current.DeleteDeploymentHostIDs(nodeID, contractID)
+				}
+			}
+		}
+	}
+}
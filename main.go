@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 
@@ -34,13 +35,9 @@ func main() {
 	flag.BoolVar(&debugMode, "debug", false, "set to true to run the provider with support for debuggers like delve")
 	flag.Parse()
 
-	stateFile := state.NewLocalFileState()
-	err := stateFile.Load(state.FileName)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-
-	providerFunc, sub := provider.New(version, &stateFile)
+	// the state backend is configured and loaded with the provider
+	st := state.NewStore()
+	providerFunc, sub := provider.New(version, st)
 	if sub != nil {
 		defer sub.Close()
 	}
@@ -50,12 +47,10 @@ func main() {
 		opts.Debug = true
 		// TODO: update this string with the full name of your provider as used in your configs
 		opts.ProviderAddr = "registry.terraform.io/hashicorp/scaffolding"
-		plugin.Serve(opts)
-		return
 	}
 
 	plugin.Serve(opts)
-	err = stateFile.Save(state.FileName)
+	err := st.Close(context.Background())
 	if err != nil {
 		log.Fatal(err.Error())
 	}